@@ -0,0 +1,217 @@
+// Command ldb-benchgate checks benchmark reports for regressions against a
+// baseline. It prints a JSON verdict and exits with status 1 if any check fails.
+//
+// Reports are matched to the baseline by the test name in the log header, so
+// log files may be named differently, e.g. by timestamp.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	bench "github.com/fjl/goleveldb-bench"
+)
+
+// tailQuantile is the latency quantile checked by the gate.
+const tailQuantile = 0.99
+
+// Verdict is the result of the gate.
+type Verdict struct {
+	Pass     bool     `json:"pass"`
+	Baseline string   `json:"baseline"`
+	Report   string   `json:"report"`
+	Checks   []Check  `json:"checks"`
+	Missing  []string `json:"missing,omitempty"` // tests in the baseline without a report
+}
+
+// Check is the comparison of one metric.
+type Check struct {
+	Test      string  `json:"test"`
+	Metric    string  `json:"metric"`
+	Baseline  float64 `json:"baseline"`
+	Value     float64 `json:"value"`
+	Change    float64 `json:"change"`    // relative change, (value-baseline)/baseline
+	Threshold float64 `json:"threshold"` // maximum allowed regression
+	Pass      bool    `json:"pass"`
+	Error     string  `json:"error,omitempty"` // why the metric couldn't be checked
+}
+
+func main() {
+	var (
+		baseline   = flag.String("baseline", "", "baseline report file or directory")
+		throughput = flag.Float64("throughput", 0.1, "maximum relative drop of mean throughput (negative disables)")
+		latency    = flag.Float64("latency", 0.2, "maximum relative increase of p99 latency (negative disables)")
+		totalTime  = flag.Float64("time", 0.1, "maximum relative increase of total time (negative disables)")
+	)
+	flag.Parse()
+	if *baseline == "" || flag.NArg() != 1 {
+		log.Fatal("usage: ldb-benchgate -baseline <file or dir> <file or dir>")
+	}
+
+	var (
+		old = byTest(bench.MustReadRuns(*baseline))
+		new = byTest(bench.MustReadRuns(flag.Arg(0)))
+		v   = gate(old, new, thresholds{*throughput, *latency, *totalTime})
+	)
+	v.Baseline, v.Report = *baseline, flag.Arg(0)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&v); err != nil {
+		log.Fatal(err)
+	}
+	if !v.Pass {
+		os.Exit(1)
+	}
+}
+
+// thresholds are the maximum allowed relative regressions of the checked
+// metrics. Negative values disable a check.
+type thresholds struct {
+	throughput, latency, time float64
+}
+
+// gate checks the runs of each test in the baseline against the new runs.
+func gate(old, new map[string][]bench.Report, t thresholds) Verdict {
+	v := Verdict{Pass: true, Checks: []Check{}}
+	for _, name := range sortedNames(old) {
+		if _, ok := new[name]; !ok {
+			v.Missing = append(v.Missing, name)
+			v.Pass = false
+			continue
+		}
+		o, oerr := summarize(old[name])
+		n, nerr := summarize(new[name])
+		if oerr != nil || nerr != nil {
+			v.fail(name, "baseline", oerr)
+			v.fail(name, "report", nerr)
+			continue
+		}
+		if t.throughput >= 0 {
+			switch {
+			case o.TotalSize > 0:
+				v.add(name, "mb/s", o.MeanBPS/1024/1024, n.MeanBPS/1024/1024, -t.throughput)
+			case o.TotalOps > 0:
+				v.add(name, "op/s", o.OPS, n.OPS, -t.throughput)
+			default:
+				v.fail(name, "throughput", errors.New("baseline has neither processed bytes nor operations"))
+			}
+		}
+		if t.latency >= 0 {
+			switch {
+			case o.EventLatency != n.EventLatency:
+				v.fail(name, "latency-p99", fmt.Errorf("can't compare %s in baseline with %s in report", latencyKind(o), latencyKind(n)))
+			case o.EventLatency:
+				v.add(name, "event-duration-p99", o.tail.Seconds(), n.tail.Seconds(), t.latency)
+			default:
+				v.add(name, "latency-p99", o.tail.Seconds(), n.tail.Seconds(), t.latency)
+			}
+		}
+		if t.time >= 0 {
+			v.add(name, "time", o.TotalTime.Seconds(), n.TotalTime.Seconds(), t.time)
+		}
+	}
+	return v
+}
+
+// latencyKind describes the latencies of a summary.
+func latencyKind(s summary) string {
+	if s.EventLatency {
+		return "event durations"
+	}
+	return "call latencies"
+}
+
+// add adds a check. A positive threshold is the maximum allowed increase, a
+// negative threshold the maximum allowed decrease. Metrics which are zero in
+// the baseline can't be checked and fail.
+func (v *Verdict) add(test, metric string, baseline, value, threshold float64) {
+	if baseline == 0 {
+		v.fail(test, metric, errors.New("baseline value is zero"))
+		return
+	}
+	c := Check{Test: test, Metric: metric, Baseline: baseline, Value: value, Change: value/baseline - 1}
+	if threshold < 0 {
+		c.Threshold = -threshold
+		c.Pass = c.Change >= threshold
+	} else {
+		c.Threshold = threshold
+		c.Pass = c.Change <= threshold
+	}
+	v.Checks = append(v.Checks, c)
+	v.Pass = v.Pass && c.Pass
+}
+
+// fail adds a failed check for a metric which can't be compared.
+func (v *Verdict) fail(test, metric string, err error) {
+	if err == nil {
+		return
+	}
+	v.Checks = append(v.Checks, Check{Test: test, Metric: metric, Error: err.Error()})
+	v.Pass = false
+}
+
+// summary is the average summary of repeated runs.
+type summary struct {
+	bench.Summary
+	tail time.Duration
+}
+
+// summarize averages the summaries of runs. It fails if a run has no progress
+// events, which happens when the benchmark failed early.
+func summarize(runs []bench.Report) (summary, error) {
+	var (
+		s    summary
+		n    = float64(len(runs))
+		tail = tailIndex()
+	)
+	for _, r := range runs {
+		rs := bench.Summarize(r)
+		if rs.Events == 0 {
+			return s, fmt.Errorf("%s has no progress events", r.Name)
+		}
+		s.TotalSize += rs.TotalSize
+		s.TotalOps += rs.TotalOps
+		s.TotalTime += rs.TotalTime / time.Duration(len(runs))
+		s.MeanBPS += rs.MeanBPS / n
+		s.OPS += rs.OPS / n
+		s.tail += rs.Latency[tail] / time.Duration(len(runs))
+		s.EventLatency = s.EventLatency || rs.EventLatency
+	}
+	return s, nil
+}
+
+func tailIndex() int {
+	for i, q := range bench.LatencyQuantiles {
+		if q == tailQuantile {
+			return i
+		}
+	}
+	panic(fmt.Sprintf("quantile %v not in bench.LatencyQuantiles", tailQuantile))
+}
+
+// byTest regroups runs by bench.Report.Test instead of the report name.
+func byTest(runs map[string][]bench.Report) map[string][]bench.Report {
+	tests := make(map[string][]bench.Report)
+	for _, reports := range runs {
+		for _, r := range reports {
+			tests[r.Test()] = append(tests[r.Test()], r)
+		}
+	}
+	return tests
+}
+
+func sortedNames(runs map[string][]bench.Report) []string {
+	var names []string
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
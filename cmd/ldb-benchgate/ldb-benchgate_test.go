@@ -0,0 +1,85 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	bench "github.com/fjl/goleveldb-bench"
+)
+
+// report creates a report with ten events. If latency is nonzero, the events
+// contain call latencies.
+func report(name string, delta uint64, latency time.Duration) bench.Report {
+	r := bench.Report{Name: name}
+	for i := 0; i < 10; i++ {
+		ev := bench.Progress{Delta: delta, Duration: 100 * time.Millisecond}
+		if latency > 0 {
+			ev.Ops, ev.Latency, ev.Hist = 1, latency, new(bench.Histogram)
+			ev.Hist.Record(latency)
+		}
+		r.Events = append(r.Events, ev)
+	}
+	return r
+}
+
+func runs(r bench.Report) map[string][]bench.Report {
+	return map[string][]bench.Report{r.Name: {r}}
+}
+
+// failed returns the failed checks of metric.
+func failed(v Verdict, metric string) []Check {
+	var checks []Check
+	for _, c := range v.Checks {
+		if c.Metric == metric && !c.Pass {
+			checks = append(checks, c)
+		}
+	}
+	return checks
+}
+
+func TestGate(t *testing.T) {
+	th := thresholds{throughput: 0.1, latency: 0.2, time: 0.1}
+	v := gate(runs(report("a", 1000, time.Millisecond)), runs(report("a", 1000, time.Millisecond)), th)
+	if !v.Pass || len(v.Checks) != 3 {
+		t.Errorf("equal reports: %+v", v)
+	}
+	v = gate(runs(report("a", 1000, time.Millisecond)), runs(report("a", 800, time.Millisecond)), th)
+	if v.Pass || len(failed(v, "mb/s")) != 1 {
+		t.Errorf("throughput regression: %+v", v)
+	}
+}
+
+func TestGateLatencyMismatch(t *testing.T) {
+	th := thresholds{throughput: 0.1, latency: 0.2, time: 0.1}
+	v := gate(runs(report("a", 1000, time.Millisecond)), runs(report("a", 1000, 0)), th)
+	if v.Pass {
+		t.Error("gate passed")
+	}
+	if c := failed(v, "latency-p99"); len(c) != 1 || c[0].Error == "" {
+		t.Errorf("latency check %+v, want one with error", c)
+	}
+}
+
+func TestGateZeroBaseline(t *testing.T) {
+	// The baseline has neither processed bytes nor operations.
+	v := gate(runs(report("a", 0, 0)), runs(report("a", 1000, 0)), thresholds{throughput: 0.1, latency: -1, time: -1})
+	if v.Pass {
+		t.Error("gate passed without baseline throughput")
+	}
+	if c := failed(v, "throughput"); len(c) != 1 || c[0].Error == "" {
+		t.Errorf("throughput check %+v, want one with error", c)
+	}
+
+	// The baseline took no time.
+	old := report("a", 1000, 0)
+	for i := range old.Events {
+		old.Events[i].Duration = 0
+	}
+	v = gate(runs(old), runs(report("a", 1000, 0)), thresholds{throughput: -1, latency: -1, time: 0.1})
+	if v.Pass {
+		t.Error("gate passed with zero baseline time")
+	}
+	if c := failed(v, "time"); len(c) != 1 || c[0].Error == "" {
+		t.Errorf("time check %+v, want one with error", c)
+	}
+}
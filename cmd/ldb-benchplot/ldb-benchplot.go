@@ -18,7 +18,7 @@ func main() {
 	var (
 		width    = flag.Int("width", 15, "with of plot in cm")
 		height   = flag.Int("height", 10, "height of plot in cm")
-		plotType = flag.String("plot", "bps", "type of plot (bps, abstime)")
+		plotType = flag.String("plot", "bps", "type of plot (bps, abstime, storage)")
 		out      = flag.String("out", "", "output filename")
 	)
 	flag.Parse()
@@ -32,6 +32,8 @@ func main() {
 		plotBPS(plt, reports)
 	case "abstime":
 		plotAbsTime(plt, reports)
+	case "storage":
+		plotStorage(plt, reports)
 	default:
 		log.Fatalf("unknown plot type %q", *plotType)
 	}
@@ -78,6 +80,48 @@ func plotAbsTime(plt *plot.Plot, reports []bench.Report) {
 	addPlots(plt, reports, toAbsTimePlot)
 }
 
+// plotStorage adds bar charts of the mean speed of each test on file and memory
+// storage.
+func plotStorage(plt *plot.Plot, reports []bench.Report) {
+	var (
+		names     []string
+		file, mem plotter.Values
+	)
+	for _, p := range bench.PairByStorage(reports) {
+		names = append(names, p.Test)
+		file = append(file, meanBPS(p.File))
+		mem = append(mem, meanBPS(p.Mem))
+	}
+	w := vg.Points(15)
+	for i, v := range []plotter.Values{file, mem} {
+		bars, err := plotter.NewBarChart(v, w)
+		if err != nil {
+			log.Fatal(err)
+		}
+		bars.Color = plotutil.Color(i)
+		bars.LineStyle.Width = 0
+		bars.Offset = w * vg.Length(2*i-1) / 2
+		plt.Add(bars)
+		plt.Legend.Add([]string{bench.StorageFile, bench.StorageMem}[i], bars)
+	}
+	plt.NominalX(names...)
+	plt.Y.Label.Text = "speed"
+	plt.Y.Tick.Marker = megabyteTicks{unit: "mb/s"}
+	plt.Legend.Top = true
+}
+
+// meanBPS returns the average throughput of the given runs.
+func meanBPS(runs []bench.Report) float64 {
+	if len(runs) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, r := range runs {
+		sum += bench.Summarize(r).MeanBPS
+	}
+	return sum / float64(len(runs))
+}
+
 type xyFunc func([]bench.Progress) plotter.XYer
 
 func addPlots(plt *plot.Plot, reports []bench.Report, toXY xyFunc) {
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bench "github.com/fjl/goleveldb-bench"
+)
+
+const alpha = 0.05
+
+// runSet contains repeated runs of the same tests, keyed by report name.
+type runSet map[string][]bench.Report
+
+func (set runSet) names() []string {
+	var names []string
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// byOp returns a copy of the set keyed by the operation type of the report
+// instead of the file name. This is used to compare two single files, which
+// may have been created by different tests.
+func (set runSet) byOp() runSet {
+	cpy := make(runSet)
+	for name, runs := range set {
+		op := ""
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			op = name[i+1:]
+		}
+		cpy[op] = runs
+	}
+	return cpy
+}
+
+// metric is a value computed from a report.
+type metric struct {
+	name   string
+	has    func(bench.Summary) bool    // reports whether the log contains the metric
+	run    func(bench.Summary) float64 // value of a whole run
+	format func(float64) string
+}
+
+var metrics = []metric{
+	{
+		name:   "mb/s",
+		has:    func(s bench.Summary) bool { return s.TotalSize > 0 },
+		run:    func(s bench.Summary) float64 { return s.MeanBPS },
+		format: func(v float64) string { return fmt.Sprintf("%.3f", v/1024/1024) },
+	},
+	{
+		name:   "op/s",
+		has:    func(s bench.Summary) bool { return s.TotalOps > 0 },
+		run:    func(s bench.Summary) float64 { return s.OPS },
+		format: func(v float64) string { return fmt.Sprintf("%.1f", v) },
+	},
+	{
+		name: "latency",
+		has:  func(s bench.Summary) bool { return s.TotalLatency > 0 },
+		run: func(s bench.Summary) float64 {
+			return float64(s.TotalLatency) / float64(s.TotalOps)
+		},
+		format: func(v float64) string { return time.Duration(v).String() },
+	},
+}
+
+// compare prints the comparison of two run sets by the per-run values. The
+// events of a run are not independent samples, so significance can only be
+// tested for tests with repetitions on both sides.
+func compare(oldPath, newPath string) {
+	var (
+		old   = runSet(bench.MustReadRuns(oldPath))
+		new   = runSet(bench.MustReadRuns(newPath))
+		names []string
+	)
+	if isFile(oldPath) && isFile(newPath) {
+		old, new = old.byOp(), new.byOp()
+	}
+	for _, name := range old.names() {
+		if _, ok := new[name]; ok {
+			names = append(names, name)
+		} else {
+			fmt.Printf("-- %s: not in %s\n", name, newPath)
+		}
+	}
+	for _, name := range new.names() {
+		if _, ok := old[name]; !ok {
+			fmt.Printf("-- %s: not in %s\n", name, oldPath)
+		}
+	}
+
+	for _, name := range names {
+		label := name
+		if label == "" {
+			label = filepath.Base(oldPath) + " vs " + filepath.Base(newPath)
+		}
+		fmt.Printf("-- %s (%d vs %d runs)\n", label, len(old[name]), len(new[name]))
+		for _, m := range metrics {
+			oldv, newv := samples(old[name], m), samples(new[name], m)
+			if len(oldv) == 0 || len(newv) == 0 {
+				continue
+			}
+			c := bench.Compare(oldv, newv)
+			fmt.Printf("  %-8s %12s -> %-12s %s\n", m.name, m.format(c.OldMean), m.format(c.NewMean), formatDelta(c))
+		}
+	}
+}
+
+// samples returns the values of metric m, or nil if any run does not have it.
+func samples(runs []bench.Report, m metric) []float64 {
+	var values []float64
+	for _, r := range runs {
+		s := bench.Summarize(r)
+		if !m.has(s) {
+			return nil
+		}
+		values = append(values, m.run(s))
+	}
+	return values
+}
+
+func formatDelta(c bench.Comparison) string {
+	if c.OldN < 2 || c.NewN < 2 {
+		return fmt.Sprintf("%+.2f%% (n/a, single run)", c.Delta*100)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%+.2f%% [%+.2f%%, %+.2f%%] (p=%.3f", c.Delta*100, c.DeltaLow*100, c.DeltaHigh*100, c.P)
+	if !c.Significant(alpha) {
+		b.WriteString(", ~")
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func isFile(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -3,32 +3,124 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
+	"strings"
 	"time"
 
 	bench "github.com/fjl/goleveldb-bench"
-	"gonum.org/v1/gonum/stat"
+)
+
+var (
+	compareFlag = flag.Bool("compare", false, "compare two report files or directories (old new)")
+	storageFlag = flag.Bool("storage", false, "show results of tests run on file and mem storage side by side")
 )
 
 func main() {
 	flag.Parse()
+	if *compareFlag {
+		if flag.NArg() != 2 {
+			log.Fatal("-compare needs two arguments: old and new report file or directory")
+		}
+		compare(flag.Arg(0), flag.Arg(1))
+		return
+	}
 	reports := bench.MustReadReports(flag.Args())
+	if *storageFlag {
+		compareStorage(reports)
+		return
+	}
 	for _, r := range reports {
-		var (
-			bps       []float64
-			weight    []float64
-			totalTime float64
-			totalSize uint64
-		)
-		for _, ev := range r.Events {
-			bps = append(bps, ev.BPS())
-			weight = append(weight, float64(ev.Duration))
-			totalTime += float64(ev.Duration) / float64(time.Second)
-			totalSize += ev.Delta
-		}
-		meanBPS, stdBPS := stat.MeanStdDev(bps, weight)
-		fmt.Printf("-- %s (%d events)", r.Name, len(r.Events))
-		fmt.Printf(" total time: %.4fs\n", totalTime)
-		fmt.Printf(" total size: %d bytes\n", totalSize)
-		fmt.Printf("  mean mb/s: %.3f (+- %.3f)\n", meanBPS/1024/1024, stdBPS/1024/1024)
+		s := bench.Summarize(r)
+		fmt.Printf("-- %s (%d events)", s.Name, s.Events)
+		fmt.Printf(" total time: %.4fs\n", s.TotalTime.Seconds())
+		fmt.Printf(" total size: %d bytes\n", s.TotalSize)
+		fmt.Printf("  mean mb/s: %.3f (+- %.3f)\n", s.MeanBPS/1024/1024, s.StdBPS/1024/1024)
+		fmt.Printf("       mb/s: %s\n", formatBPSQuantiles(s))
+		if s.TotalOps > 0 {
+			fmt.Printf("  mean op/s: %.1f\n", s.OPS)
+		}
+		if s.TotalEntries > 0 {
+			fmt.Printf("  mean entries/s: %.1f\n", s.EntriesPS)
+		}
+		if s.EventLatency {
+			fmt.Printf("  event duration: %s\n", formatLatency(s))
+		} else {
+			fmt.Printf("  mean latency: %v\n", r.Histogram().Mean())
+			fmt.Printf("       latency: %s\n", formatLatency(s))
+		}
+		fmt.Printf("  longest stall: %v\n", s.LongestStall)
+		if s.WriteAmp > 0 {
+			fmt.Printf("  write amplification: %.2f\n", s.WriteAmp)
+		}
+		if s.ReadAmp > 0 {
+			fmt.Printf("  read amplification: %.2f\n", s.ReadAmp)
+		}
+		if s.SpaceAmp > 0 {
+			fmt.Printf("  space amplification: %.2f\n", s.SpaceAmp)
+		}
+		if st := lastStats(r, func(st bench.Stats) bool { return st.DB != nil }); st != nil {
+			printDBStats(st.DB)
+		}
+		if st := lastStats(r, func(st bench.Stats) bool { return st.Storage != nil }); st != nil {
+			printStorageStats(st.Storage)
+		}
+	}
+}
+
+// lastStats returns the last stats record of the run which matches fn.
+func lastStats(r bench.Report, fn func(bench.Stats) bool) *bench.Stats {
+	for i := len(r.Stats) - 1; i >= 0; i-- {
+		if fn(r.Stats[i]) {
+			return &r.Stats[i]
+		}
+	}
+	return nil
+}
+
+func printDBStats(db *bench.DBStats) {
+	var (
+		compTime    time.Duration
+		read, write int64
+	)
+	for i := range db.LevelDurations {
+		compTime += db.LevelDurations[i]
+		read += db.LevelRead[i]
+		write += db.LevelWrite[i]
+	}
+	fmt.Printf("  write delay: %d times, %v\n", db.WriteDelayCount, db.WriteDelayDuration)
+	fmt.Printf("  compaction: %v, %.1f mb read, %.1f mb written\n", compTime, float64(read)/1024/1024, float64(write)/1024/1024)
+}
+
+// formatBPSQuantiles prints the time-weighted throughput quantiles. For
+// example, "p1" is the throughput which was undercut during 1% of the run.
+func formatBPSQuantiles(s bench.Summary) string {
+	var parts []string
+	for i, q := range bench.ThroughputQuantiles {
+		parts = append(parts, fmt.Sprintf("p%s %.3f", formatPercent(q), s.BPSQuantiles[i]/1024/1024))
+	}
+	return strings.Join(parts, "  ")
+}
+
+func formatLatency(s bench.Summary) string {
+	var parts []string
+	for i, q := range bench.LatencyQuantiles {
+		parts = append(parts, fmt.Sprintf("p%s %v", formatPercent(q), s.Latency[i]))
+	}
+	parts = append(parts, fmt.Sprintf("max %v", s.MaxLatency))
+	return strings.Join(parts, "  ")
+}
+
+func formatPercent(q float64) string {
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", q*100), ".0")
+}
+
+func printStorageStats(counts map[string]*bench.IOCount) {
+	for _, ft := range []string{"journal", "table", "manifest", "temp", "other"} {
+		c := counts[ft]
+		if c == nil || c.Writes+c.Reads == 0 {
+			continue
+		}
+		fmt.Printf("  %s i/o: %.1f mb written, %.1f mb read, %d syncs (total %v, max %v)\n",
+			ft, float64(c.WriteBytes)/1024/1024, float64(c.ReadBytes)/1024/1024, c.Syncs, c.SyncTime.Sum(), c.SyncTime.Max())
 	}
 }
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	bench "github.com/fjl/goleveldb-bench"
+	"gonum.org/v1/gonum/stat"
+)
+
+// compareStorage prints the results of each test on file and memory storage
+// side by side. The memory results are the ceiling set by CPU work, the
+// file/mem column shows how much of it is reached on disk.
+func compareStorage(reports []bench.Report) {
+	for _, p := range bench.PairByStorage(reports) {
+		if len(p.File) == 0 || len(p.Mem) == 0 {
+			fmt.Printf("-- %s: not run on both file and mem storage\n", p.Test)
+			continue
+		}
+		fmt.Printf("-- %s (%d vs %d runs)\n", p.Test, len(p.File), len(p.Mem))
+		fmt.Printf("  %-8s %12s %12s  %s\n", "", "file", "mem", "file/mem")
+		for _, m := range metrics {
+			filev, memv := samples(p.File, m), samples(p.Mem, m)
+			if len(filev) == 0 || len(memv) == 0 {
+				continue
+			}
+			f, mem := stat.Mean(filev, nil), stat.Mean(memv, nil)
+			fmt.Printf("  %-8s %12s %12s  %.1f%%\n", m.name, m.format(f), m.format(mem), f/mem*100)
+		}
+	}
+}
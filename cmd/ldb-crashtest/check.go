@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// maxReported is the number of missing ranges and unexpected keys logged.
+const maxReported = 10
+
+// keyRange is an inclusive range of key indices.
+type keyRange struct {
+	start, end uint64
+}
+
+func (r keyRange) String() string {
+	if r.start == r.end {
+		return fmt.Sprint(r.start)
+	}
+	return fmt.Sprintf("%d-%d", r.start, r.end)
+}
+
+// checkDB opens the database and checks that it contains exactly the keys
+// written by test t, with correct values. Keys up to index acked were
+// acknowledged by the writer, one more batch may have been in flight.
+//
+// The keys in the database must be a prefix of the key sequence, i.e. there
+// must be no holes, and batches must be applied completely or not at all.
+// Losing acknowledged keys is an error if the writes were durable. Otherwise
+// the number of lost keys is logged.
+//
+// checkDB returns the index of the first key which is not in the database.
+func checkDB(dbdir string, acked int64, t tester) (uint64, error) {
+	db, err := leveldb.OpenFile(dbdir, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var (
+		size     = uint64(t.batchSize())
+		limit    = uint64(acked + int64(size)) // highest index which may be present
+		found    uint64
+		missing  []keyRange
+		checkErr error
+	)
+	iterateTestKeys(0, func(i uint64, k, v []byte) bool {
+		value, err := db.Get(k, nil)
+		switch {
+		case err == leveldb.ErrNotFound:
+			if n := len(missing); n > 0 && missing[n-1].end == i-1 {
+				missing[n-1].end = i
+			} else {
+				missing = append(missing, keyRange{i, i})
+			}
+		case err != nil:
+			checkErr = fmt.Errorf("can't read key %d: %v", i, err)
+		case !bytes.Equal(value, v):
+			checkErr = fmt.Errorf("mismatch for key %d (%x): want %x, found %x", i, k, v, value)
+		default:
+			found++
+		}
+		return checkErr != nil || i >= limit
+	})
+	if checkErr != nil {
+		return 0, checkErr
+	}
+
+	res := analyze(missing, limit, acked, size)
+	if res.next == 0 {
+		log.Printf("  == database is empty")
+	} else {
+		log.Printf("  == database has keys up to %d", res.next-1)
+	}
+	var problems []string
+	if len(res.holes) > 0 {
+		log.Printf("  == missing keys: %s", formatRanges(res.holes))
+		problems = append(problems, fmt.Sprintf("%d holes", len(res.holes)))
+	}
+	if len(res.partial) > 0 {
+		log.Printf("  == partially applied batches: %s", formatRanges(res.partial))
+		problems = append(problems, fmt.Sprintf("%d batches partially applied", len(res.partial)))
+	}
+
+	// Keys which are not part of the sequence are found by comparing the
+	// number of entries.
+	total := countEntries(db)
+	if total > found {
+		log.Printf("  == unexpected keys: %s", strings.Join(unexpectedKeys(db, limit), ", "))
+		problems = append(problems, fmt.Sprintf("%d unexpected keys", total-found))
+	}
+
+	if res.lost > 0 {
+		if t.durable() {
+			problems = append(problems, fmt.Sprintf("%d acknowledged writes lost", res.lost))
+		} else {
+			log.Printf("  == %d acknowledged writes lost (no sync)", res.lost)
+		}
+	}
+	if len(problems) > 0 {
+		return res.next, errors.New(strings.Join(problems, ", "))
+	}
+	return res.next, nil
+}
+
+// checkResult is the analysis of the keys missing from the database.
+type checkResult struct {
+	next    uint64     // index of the first key which is not in the database
+	holes   []keyRange // missing keys before the last present key
+	partial []keyRange // batches which are partially in the database
+	lost    uint64     // number of acknowledged keys which are not in the database
+}
+
+// analyze finds holes, partially applied batches and lost acknowledged writes.
+// missing are the sorted ranges of keys up to index limit which are not in the
+// database. Keys up to index acked were acknowledged, batches have the given
+// size.
+func analyze(missing []keyRange, limit uint64, acked int64, size uint64) checkResult {
+	// Keys after the last present key were not written. All other missing
+	// ranges are holes.
+	var (
+		res  = checkResult{next: limit + 1, holes: missing}
+		tail *keyRange
+	)
+	if n := len(missing); n > 0 && missing[n-1].end == limit {
+		res.holes, tail = missing[:n-1], &missing[n-1]
+		res.next = tail.start
+	}
+	for i, r := range missing {
+		if r.start%size != 0 {
+			res.partial = appendBatch(res.partial, batchRange(r.start, size))
+		}
+		if &missing[i] != tail && (r.end+1)%size != 0 {
+			res.partial = appendBatch(res.partial, batchRange(r.end, size))
+		}
+	}
+	if tail != nil && int64(tail.start) <= acked {
+		res.lost = uint64(acked) - tail.start + 1
+	}
+	return res
+}
+
+// batchRange returns the range of the batch containing index i.
+func batchRange(i, size uint64) keyRange {
+	start := i - i%size
+	return keyRange{start, start + size - 1}
+}
+
+// appendBatch adds a batch to the list unless it is already the last element.
+// Missing ranges are sorted, so a batch can only repeat at the end.
+func appendBatch(batches []keyRange, b keyRange) []keyRange {
+	if n := len(batches); n > 0 && batches[n-1] == b {
+		return batches
+	}
+	return append(batches, b)
+}
+
+func formatRanges(ranges []keyRange) string {
+	var s []string
+	for i, r := range ranges {
+		if i == maxReported {
+			s = append(s, fmt.Sprintf("... (%d more)", len(ranges)-i))
+			break
+		}
+		s = append(s, r.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// countEntries returns the number of entries in the database.
+func countEntries(db *leveldb.DB) uint64 {
+	var n uint64
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() {
+		n++
+	}
+	return n
+}
+
+// unexpectedKeys returns the keys in the database which are not in the key
+// sequence up to index limit.
+func unexpectedKeys(db *leveldb.DB, limit uint64) []string {
+	expected := make(map[string]bool)
+	iterateTestKeys(0, func(i uint64, k, v []byte) bool {
+		expected[string(k)] = true
+		return i >= limit
+	})
+	var keys []string
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+	for it.Next() && len(keys) < maxReported {
+		if !expected[string(it.Key())] {
+			keys = append(keys, fmt.Sprintf("%x", it.Key()))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	const size = 10
+	tests := []struct {
+		name    string
+		missing []keyRange
+		acked   int64
+		want    checkResult
+	}{
+		{
+			name:    "empty, nothing acknowledged",
+			missing: []keyRange{{0, 9}},
+			acked:   -1,
+			want:    checkResult{next: 0},
+		},
+		{
+			name:    "empty",
+			missing: []keyRange{{0, 39}},
+			acked:   29,
+			want:    checkResult{next: 0, lost: 30},
+		},
+		{
+			name:    "complete",
+			missing: nil,
+			acked:   29,
+			want:    checkResult{next: 40},
+		},
+		{
+			name:    "in-flight batch not applied",
+			missing: []keyRange{{30, 39}},
+			acked:   29,
+			want:    checkResult{next: 30},
+		},
+		{
+			name:    "hole in the middle",
+			missing: []keyRange{{10, 19}, {30, 39}},
+			acked:   29,
+			want:    checkResult{next: 30, holes: []keyRange{{10, 19}}},
+		},
+		{
+			name:    "partial first batch",
+			missing: []keyRange{{0, 4}, {30, 39}},
+			acked:   29,
+			want: checkResult{
+				next:    30,
+				holes:   []keyRange{{0, 4}},
+				partial: []keyRange{{0, 9}},
+			},
+		},
+		{
+			name:    "partial last batch",
+			missing: []keyRange{{25, 39}},
+			acked:   29,
+			want:    checkResult{next: 25, partial: []keyRange{{20, 29}}, lost: 5},
+		},
+		{
+			name:    "tail starts mid-batch",
+			missing: []keyRange{{35, 39}},
+			acked:   29,
+			want:    checkResult{next: 35, partial: []keyRange{{30, 39}}},
+		},
+		{
+			name:    "hole within a batch",
+			missing: []keyRange{{12, 14}, {30, 39}},
+			acked:   29,
+			want: checkResult{
+				next:    30,
+				holes:   []keyRange{{12, 14}},
+				partial: []keyRange{{10, 19}},
+			},
+		},
+	}
+	for _, test := range tests {
+		limit := uint64(test.acked + size)
+		got := analyze(test.missing, limit, test.acked, size)
+		if len(got.holes) == 0 {
+			got.holes = nil
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
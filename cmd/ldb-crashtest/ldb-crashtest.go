@@ -1,23 +1,26 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha1"
 	"encoding/binary"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	bench "github.com/fjl/goleveldb-bench"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
 )
 
 func main() {
@@ -33,6 +36,8 @@ func main() {
 		timeflag  = flag.Duration("time", 30*time.Second, "time to wait before terminating the writer process")
 		dirflag   = flag.String("dir", ".", "test database directory")
 		countflag = flag.Uint("count", 1000, "number of test repetitions")
+		powerflag = flag.String("powerloss", "", "simulate power loss after terminating the writer (drop, tear)")
+		cycleflag = flag.Uint("cycles", 1, "number of crashes of the same database per test repetition")
 		run       []string
 	)
 	flag.Parse()
@@ -46,12 +51,18 @@ func main() {
 	if len(run) == 0 {
 		log.Fatal("no tests to run, use -test to select tests")
 	}
+	if *powerflag != "" && *powerflag != "drop" && *powerflag != "tear" {
+		log.Fatalf("-powerloss: unknown mode %q", *powerflag)
+	}
+	if *cycleflag == 0 {
+		log.Fatal("-cycles must be at least 1")
+	}
 
 	anyErr := false
 	for _, name := range run {
 		for i := uint(1); i <= *countflag; i++ {
 			log.Printf("== running test %q (%d/%d)", name, i, *countflag)
-			if err := runTest(*dirflag, name, *timeflag); err != nil {
+			if err := runTest(*dirflag, name, *timeflag, *powerflag, *cycleflag); err != nil {
 				log.Printf("test %q failed: %v", name, err)
 				anyErr = true
 			}
@@ -62,7 +73,14 @@ func main() {
 	}
 }
 
-func runTest(basedir, name string, avgwait time.Duration) error {
+// runTest runs the writer on a fresh database and checks the database after
+// terminating it. With more than one cycle, the same database is reopened and
+// written again after each check, resuming at the first key which is not in the
+// database. The writer is then terminated at uniformly random times up to twice
+// the average wait time, so some crashes happen while the database recovers
+// from the previous one. A test without sync ends when its database is damaged
+// by a power loss, which isn't a failure.
+func runTest(basedir, name string, avgwait time.Duration, powerloss string, cycles uint) error {
 	thiscmd, err := os.Executable()
 	if err != nil {
 		log.Fatalf("can't figure out executable path: %v", err)
@@ -72,57 +90,166 @@ func runTest(basedir, name string, avgwait time.Duration) error {
 		return err
 	}
 
-	// Start the writer process and terminate it on a randomized timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), randomWaitTime(avgwait))
+	var next uint64
+	for c := uint(1); c <= cycles; c++ {
+		wait := randomWaitTime(avgwait)
+		if cycles > 1 {
+			log.Printf("  -- cycle %d/%d, writing from key %d", c, cycles, next)
+			wait = time.Duration(rand.Int63n(int64(2*avgwait) + 1))
+		}
+		next, err = runCycle(thiscmd, dbdir, name, next, wait, powerloss)
+		if loss, ok := err.(expectedLoss); ok {
+			log.Printf("  == database damaged by power loss (no sync): %v", loss.err)
+			return nil
+		}
+		if err != nil {
+			if cycles > 1 {
+				err = fmt.Errorf("cycle %d: %v", c, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// runCycle runs the writer from key index start, terminates it after the wait
+// time and checks the database. It returns the index of the first key which
+// is not in the database.
+func runCycle(thiscmd, dbdir, name string, start uint64, wait time.Duration, powerloss string) (uint64, error) {
+	// For power loss simulation, the writer records synced file sizes in
+	// the state file. The existing files are recorded before the writer
+	// starts, in case it is terminated before it opens the database.
+	args := []string{"-writer", dbdir, name, strconv.FormatUint(start, 10)}
+	statefile := dbdir + ".synced"
+	if powerloss != "" {
+		if err := createState(dbdir, statefile); err != nil {
+			return 0, err
+		}
+		args = append(args, statefile)
+	}
+
+	// Start the writer process and terminate it on the timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
 	defer cancel()
-	writer := exec.CommandContext(ctx, thiscmd, "-writer", dbdir, name)
+	writer := exec.CommandContext(ctx, thiscmd, args...)
 	writer.Stdout, writer.Stderr = os.Stdout, os.Stderr
-	writer.Run()
+	acked, err := runWriter(writer)
+	if err != nil {
+		return 0, err
+	}
+	// Keys before start were verified by the previous cycle.
+	if acked < int64(start)-1 {
+		acked = int64(start) - 1
+	}
 
-	return checkDB(dbdir)
+	if powerloss != "" {
+		if err := simulatePowerLoss(dbdir, statefile, powerloss == "tear"); err != nil {
+			return 0, fmt.Errorf("power loss simulation failed: %v", err)
+		}
+	}
+	next, err := checkDB(dbdir, acked, tests[name])
+	if err != nil && powerloss != "" && !tests[name].durable() {
+		err = expectedLoss{err}
+	}
+	return next, err
 }
 
-func randomWaitTime(avg time.Duration) time.Duration {
-	wiggle := 500 * time.Millisecond
-	if wiggle > avg {
-		wiggle = avg / 2
+// expectedLoss is returned by runCycle when the database of a test without
+// sync doesn't pass the check after a power loss. goleveldb doesn't sync
+// tables and MANIFEST updates with NoSync, so the database may be damaged.
+type expectedLoss struct{ err error }
+
+func (e expectedLoss) Error() string { return e.err.Error() }
+
+// ackFD is the file descriptor of the acknowledgement pipe in the writer
+// process. It is the first of exec.Cmd.ExtraFiles.
+const ackFD = 3
+
+// runWriter runs the writer process until it exits and returns the highest
+// index it acknowledged, or -1 if no write was acknowledged.
+func runWriter(writer *exec.Cmd) (int64, error) {
+	ackr, ackw, err := os.Pipe()
+	if err != nil {
+		return 0, err
 	}
-	r := time.Duration(rand.Int63n(int64(wiggle)))
-	return avg - wiggle/2 + r
+	defer ackr.Close()
+	writer.ExtraFiles = []*os.File{ackw}
+	err = writer.Start()
+	ackw.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	// Acknowledgements are 8-byte indices. The pipe is at EOF when the writer
+	// exits, which happens when it's terminated.
+	var (
+		acked = int64(-1)
+		buf   [8]byte
+	)
+	for {
+		if _, err := io.ReadFull(ackr, buf[:]); err != nil {
+			break
+		}
+		acked = int64(binary.BigEndian.Uint64(buf[:]))
+	}
+	writer.Wait()
+	log.Printf("  == writer acknowledged keys up to %d", acked)
+	return acked, nil
 }
 
-// checkDB opens the database and checks whether all keys are present and
-// the correct value is stored for each keys.
-func checkDB(dbdir string) error {
-	db, err := leveldb.OpenFile(dbdir, nil)
+// createState records the files of the database as synced in the power loss
+// state file.
+func createState(dbdir, statefile string) error {
+	state, err := os.Create(statefile)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer state.Close()
+	stor, err := storage.OpenFile(dbdir, false)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+	_, err = bench.NewPowerLossStorage(stor, state)
+	return err
+}
 
-	var checkErr error
-	var maxIndex uint64
-	iterateTestKeys(func(i uint64, k, v []byte) bool {
-		value, err := db.Get(k, nil)
-		if err != nil {
-			return true
-		}
-		maxIndex = i
-		if !bytes.Equal(value, v) {
-			checkErr = fmt.Errorf("mismatch for key %x: want %x, found %x", k, value, v)
-		}
-		return checkErr != nil
-	})
-	log.Printf("  == database has keys up to %d", maxIndex)
-	return checkErr
+// simulatePowerLoss discards or tears the writes to dbdir which were not synced
+// before the writer was terminated.
+func simulatePowerLoss(dbdir, statefile string, tear bool) error {
+	state, err := os.Open(statefile)
+	if err != nil {
+		return err
+	}
+	defer state.Close()
+	stor, err := storage.OpenFile(dbdir, false)
+	if err != nil {
+		return err
+	}
+	defer stor.Close()
+	lost, err := bench.SimulatePowerLoss(stor, state, tear, rand.New(rand.NewSource(time.Now().UnixNano())))
+	if err != nil {
+		return err
+	}
+	log.Printf("  == power loss: %d bytes of unsynced data lost", lost)
+	return nil
 }
 
-// iterateTestKeys calls fn with keys and values until it returns true.
-// The keys and values are 32-byte values.
-func iterateTestKeys(fn func(i uint64, k, v []byte) bool) {
+func randomWaitTime(avg time.Duration) time.Duration {
+	wiggle := 500 * time.Millisecond
+	if wiggle > avg {
+		wiggle = avg / 2
+	}
+	r := time.Duration(rand.Int63n(int64(wiggle)))
+	return avg - wiggle/2 + r
+}
+
+// iterateTestKeys calls fn with keys and values, starting at index start,
+// until it returns true. The keys and values are 32-byte values.
+func iterateTestKeys(start uint64, fn func(i uint64, k, v []byte) bool) {
 	var n, k, v [32]byte
 	hash := sha1.New()
-	for i := uint64(0); ; i++ {
+	for i := start; ; i++ {
 		binary.BigEndian.PutUint64(n[:], i)
 		hash.Write(n[:])
 		hash.Sum(k[:0])
@@ -135,13 +262,25 @@ func iterateTestKeys(fn func(i uint64, k, v []byte) bool) {
 	}
 }
 
+// stateFile receives the sync records of bench.PowerLossStorage in the writer
+// process. It is empty if power loss isn't simulated.
+var stateFile string
+
 // writer is the main function of the child process.
 func writer() {
-	if len(os.Args) != 4 {
+	if len(os.Args) != 5 && len(os.Args) != 6 {
 		log.Fatal("invalid number of arguments")
 	}
 	dbdir, name := os.Args[2], os.Args[3]
-	if err := tests[name].test(dbdir); err != nil {
+	start, err := strconv.ParseUint(os.Args[4], 10, 64)
+	if err != nil {
+		log.Fatal("invalid start index: ", err)
+	}
+	if len(os.Args) == 6 {
+		stateFile = os.Args[5]
+	}
+	ack := &acker{w: os.NewFile(ackFD, "ack")}
+	if err := tests[name].test(dbdir, start, ack); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -163,26 +302,70 @@ func testnames() (n []string) {
 }
 
 type tester interface {
-	test(dbdir string) error
+	// test writes keys from index start until the process is terminated.
+	// Each write is reported to the parent process after the database
+	// accepted it.
+	test(dbdir string, start uint64, ack *acker) error
+	// durable reports whether acknowledged writes must survive a crash.
+	durable() bool
+	// batchSize is the number of keys written at once. The writes of a
+	// batch are acknowledged together and must be applied atomically.
+	batchSize() int
+}
+
+// acker reports acknowledged writes to the parent process.
+type acker struct {
+	w   io.Writer
+	buf [8]byte
+}
+
+// ack reports that all keys up to index i are written.
+func (a *acker) ack(i uint64) error {
+	binary.BigEndian.PutUint64(a.buf[:], i)
+	_, err := a.w.Write(a.buf[:])
+	return err
+}
+
+// openDB opens the test database in the writer process.
+func openDB(dbdir string, o *opt.Options) (*leveldb.DB, error) {
+	if stateFile == "" {
+		return leveldb.OpenFile(dbdir, o)
+	}
+	state, err := os.OpenFile(stateFile, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return nil, err
+	}
+	stor, err := storage.OpenFile(dbdir, false)
+	if err != nil {
+		return nil, err
+	}
+	ps, err := bench.NewPowerLossStorage(stor, state)
+	if err != nil {
+		return nil, err
+	}
+	return leveldb.Open(ps, o)
 }
 
 type seqWrite struct {
 	sync bool
 }
 
-func (t seqWrite) test(dbdir string) error {
-	db, err := leveldb.OpenFile(dbdir, &opt.Options{NoSync: !t.sync})
+func (t seqWrite) durable() bool  { return t.sync }
+func (t seqWrite) batchSize() int { return 1 }
+
+func (t seqWrite) test(dbdir string, start uint64, ack *acker) error {
+	db, err := openDB(dbdir, &opt.Options{NoSync: !t.sync})
 	if err != nil {
 		return err
 	}
-	iterateTestKeys(func(i uint64, k, v []byte) bool {
-		db.Put(k, v, nil)
-		if i > 0 && i%10000 == 0 {
-			fmt.Printf("%d\n", i)
+	wo := &opt.WriteOptions{Sync: t.sync}
+	iterateTestKeys(start, func(i uint64, k, v []byte) bool {
+		if err = db.Put(k, v, wo); err == nil {
+			err = ack.ack(i)
 		}
-		return false
+		return err != nil
 	})
-	return nil
+	return err
 }
 
 type batchWrite struct {
@@ -190,20 +373,27 @@ type batchWrite struct {
 	size int
 }
 
-func (t batchWrite) test(dbdir string) error {
-	db, err := leveldb.OpenFile(dbdir, &opt.Options{NoSync: !t.sync})
+func (t batchWrite) durable() bool  { return t.sync }
+func (t batchWrite) batchSize() int { return t.size }
+
+func (t batchWrite) test(dbdir string, start uint64, ack *acker) error {
+	db, err := openDB(dbdir, &opt.Options{NoSync: !t.sync})
 	if err != nil {
 		return err
 	}
-	var batch leveldb.Batch
-	iterateTestKeys(func(i uint64, k, v []byte) bool {
+	var (
+		batch leveldb.Batch
+		wo    = &opt.WriteOptions{Sync: t.sync}
+	)
+	iterateTestKeys(start, func(i uint64, k, v []byte) bool {
 		batch.Put(k, v)
-		if i > 0 && i%10000 == 0 {
-			db.Write(&batch, nil)
+		if (i+1)%uint64(t.size) == 0 {
+			if err = db.Write(&batch, wo); err == nil {
+				err = ack.ack(i)
+			}
 			batch.Reset()
-			fmt.Printf("%d\n", i)
 		}
-		return false
+		return err != nil
 	})
-	return nil
+	return err
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bench "github.com/fjl/goleveldb-bench"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func main() {
+	var (
+		testflag     = flag.String("test", "", "tests to run ("+strings.Join(testnames(), ", ")+")")
+		sizeflag     = flag.String("size", "500mb", "total amount of value data to load")
+		datasizeflag = flag.String("valuesize", "100b", "size of each value")
+		keysizeflag  = flag.String("keysize", "32b", "size of each key")
+		opsflag      = flag.Uint64("ops", 1000000, "number of operations after loading")
+		emitsizeflag = flag.String("emitsize", "", "write a progress event after this amount of data per operation type (default 500kb without -emitinterval)")
+		emitintflag  = flag.Duration("emitinterval", 0, "write a progress event at this interval, also during stalls")
+		statsflag    = flag.Duration("statsinterval", time.Second, "interval of database statistics in the log (0 to disable)")
+		dirflag      = flag.String("dir", ".", "test database directory")
+		logdirflag   = flag.String("logdir", ".", "test log output directory")
+		deletedbflag = flag.Bool("deletedb", false, "delete databases after test run")
+		countioflag  = flag.Bool("countio", false, "log I/O totals per database file type")
+
+		run []string
+		cfg bench.MixedConfig
+		err error
+	)
+	flag.Parse()
+
+	for _, t := range strings.Split(*testflag, ",") {
+		t = strings.TrimSpace(t)
+		if tests[t] == nil {
+			log.Fatalf("unknown test %q", t)
+		}
+		run = append(run, t)
+	}
+	if len(run) == 0 {
+		log.Fatal("no tests to run, use -test to select tests")
+	}
+	if cfg.Size, err = bench.ParseSize(*sizeflag); err != nil {
+		log.Fatal("-size: ", err)
+	}
+	if cfg.DataSize, err = bench.ParseSize(*datasizeflag); err != nil {
+		log.Fatal("-datasize: ", err)
+	}
+	if cfg.KeySize, err = bench.ParseSize(*keysizeflag); err != nil {
+		log.Fatal("-datasize: ", err)
+	}
+	cfg.Operations = *opsflag
+	if *emitsizeflag != "" {
+		if cfg.EmitSize, err = bench.ParseSize(*emitsizeflag); err != nil {
+			log.Fatal("-emitsize: ", err)
+		}
+	}
+	cfg.EmitInterval = *emitintflag
+	cfg.StatsInterval = *statsflag
+	cfg.CountIO = *countioflag
+	cfg.LogPercent = true
+
+	if err := os.MkdirAll(*logdirflag, 0755); err != nil {
+		log.Fatal("can't create log dir: ", err)
+	}
+
+	anyErr := false
+	for _, name := range run {
+		dbdir := filepath.Join(*dirflag, "testdb-"+name)
+		if err := os.RemoveAll(dbdir); err != nil {
+			log.Fatal("can't remove old database: ", err)
+		}
+		if err := runTest(*logdirflag, dbdir, name, cfg); err != nil {
+			log.Printf("test %q failed: %v", name, err)
+			anyErr = true
+		}
+		if *deletedbflag {
+			os.RemoveAll(dbdir)
+		}
+	}
+	if anyErr {
+		log.Fatal("one ore more tests failed")
+	}
+}
+
+func runTest(logdir, dbdir, name string, cfg bench.MixedConfig) error {
+	cfg.TestName = name
+	logfile, err := os.Create(filepath.Join(logdir, name+".json"))
+	if err != nil {
+		return err
+	}
+	defer logfile.Close()
+	log.Printf("== running %q", name)
+	env := bench.NewMixedEnv(logfile, cfg)
+	env.Describe(dbdir, tests[name])
+	return tests[name].Benchmark(dbdir, env)
+}
+
+type Benchmarker interface {
+	Benchmark(dir string, env *bench.MixedEnv) error
+}
+
+var tests = map[string]Benchmarker{
+	"ycsb-a": ycsb{Workload: bench.WorkloadA},
+	"ycsb-b": ycsb{Workload: bench.WorkloadB},
+	"ycsb-c": ycsb{Workload: bench.WorkloadC},
+	"ycsb-d": ycsb{Workload: bench.WorkloadD},
+	"ycsb-e": ycsb{Workload: bench.WorkloadE},
+	"ycsb-f": ycsb{Workload: bench.WorkloadF},
+
+	"ycsb-a-bigcache-filter": ycsb{Workload: bench.WorkloadA, Options: opt.Options{
+		BlockCacheCapacity: 100 * opt.MiB,
+		Filter:             filter.NewBloomFilter(10),
+	}},
+	"ycsb-b-bigcache-filter": ycsb{Workload: bench.WorkloadB, Options: opt.Options{
+		BlockCacheCapacity: 100 * opt.MiB,
+		Filter:             filter.NewBloomFilter(10),
+	}},
+	"ycsb-e-bigcache": ycsb{Workload: bench.WorkloadE, Options: opt.Options{
+		BlockCacheCapacity: 100 * opt.MiB,
+	}},
+}
+
+func testnames() (n []string) {
+	for name := range tests {
+		n = append(n, name)
+	}
+	sort.Strings(n)
+	return n
+}
+
+// openDB opens the test database. With -countio, it is opened through a
+// bench.CountingStorage.
+func openDB(dir string, o *opt.Options, env *bench.MixedEnv) (*bench.DB, error) {
+	return bench.OpenDB(dir, o, env.StorageConfig(), env.AddSampler)
+}
+
+type ycsb struct {
+	Workload bench.Workload
+	Options  opt.Options
+}
+
+func (b ycsb) Benchmark(dir string, env *bench.MixedEnv) error {
+	db, err := openDB(dir, &b.Options, env)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return env.Run(b.Workload, mixedDB{db.DB})
+}
+
+// mixedDB implements bench.MixedDB.
+type mixedDB struct{ db *leveldb.DB }
+
+func (m mixedDB) Put(key, value string) error {
+	return m.db.Put([]byte(key), []byte(value), nil)
+}
+
+func (m mixedDB) Get(key string) (int, error) {
+	v, err := m.db.Get([]byte(key), nil)
+	return len(v), err
+}
+
+func (m mixedDB) Scan(start string, n int) (int, error) {
+	it := m.db.NewIterator(&util.Range{Start: []byte(start)}, nil)
+	defer it.Release()
+	size := 0
+	for i := 0; i < n && it.Next(); i++ {
+		size += len(it.Value())
+	}
+	return size, it.Error()
+}
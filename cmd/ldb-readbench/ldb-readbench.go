@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -11,9 +10,9 @@ import (
 	"time"
 
 	bench "github.com/fjl/goleveldb-bench"
-	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func main() {
@@ -22,9 +21,17 @@ func main() {
 		sizeflag     = flag.String("size", "500mb", "total amount of value data to write")
 		datasizeflag = flag.String("valuesize", "100b", "size of each value")
 		keysizeflag  = flag.String("keysize", "32b", "size of each key")
+		missflag     = flag.Float64("missratio", 0, "fraction of reads that look up absent keys")
+		emitsizeflag = flag.String("emitsize", "", "write a progress event after this amount of data (default 500kb without -emitinterval)")
+		emitintflag  = flag.Duration("emitinterval", 0, "write a progress event at this interval, also during stalls")
+		orderflag    = flag.String("order", bench.ReadShuffle, "read order ("+strings.Join(bench.ReadOrders, ", ")+")")
+		statsflag    = flag.Duration("statsinterval", time.Second, "interval of database statistics in the log (0 to disable)")
 		dirflag      = flag.String("dir", ".", "test database directory")
 		logdirflag   = flag.String("logdir", ".", "test log output directory")
 		deletedbflag = flag.Bool("deletedb", false, "delete databases after test run")
+		storageflag  = flag.String("storage", bench.StorageFile, "storage of the test database ("+bench.StorageFile+", "+bench.StorageMem+")")
+		diskflag     = flag.String("diskprofile", "", "simulate disk performance on memory storage, implies -storage=mem ("+strings.Join(bench.DiskProfileNames(), ", ")+")")
+		countioflag  = flag.Bool("countio", false, "log I/O totals per database file type")
 
 		run []string
 		cfg bench.ReadConfig
@@ -50,6 +57,36 @@ func main() {
 	if cfg.KeySize, err = bench.ParseSize(*keysizeflag); err != nil {
 		log.Fatal("-datasize: ", err)
 	}
+	for _, t := range run {
+		if s, ok := tests[t].(scanRead); ok && uint64(s.Prefix) > cfg.KeySize {
+			log.Fatalf("test %q scans a %d byte prefix, -keysize must be at least that", t, s.Prefix)
+		}
+	}
+	if cfg.Order = *orderflag; !isReadOrder(cfg.Order) {
+		log.Fatalf("-order: unknown read order %q", cfg.Order)
+	}
+	if *emitsizeflag != "" {
+		if cfg.EmitSize, err = bench.ParseSize(*emitsizeflag); err != nil {
+			log.Fatal("-emitsize: ", err)
+		}
+	}
+	cfg.EmitInterval = *emitintflag
+	if cfg.MissRatio = *missflag; cfg.MissRatio < 0 || cfg.MissRatio > 1 {
+		log.Fatalf("-missratio: must be between 0 and 1")
+	}
+	cfg.StatsInterval = *statsflag
+	if _, ok := bench.DiskProfiles[*diskflag]; !ok && *diskflag != "" {
+		log.Fatalf("-diskprofile: unknown disk profile %q", *diskflag)
+	}
+	cfg.DiskProfile = *diskflag
+	cfg.CountIO = *countioflag
+	if cfg.Storage = *storageflag; cfg.Storage != bench.StorageFile && cfg.Storage != bench.StorageMem {
+		log.Fatalf("-storage: unknown storage type %q", cfg.Storage)
+	}
+	if cfg.DiskProfile != "" {
+		// The simulated disk is backed by memory.
+		cfg.Storage = bench.StorageMem
+	}
 	cfg.LogPercent = true
 
 	if err := os.MkdirAll(*logdirflag, 0755); err != nil {
@@ -63,8 +100,9 @@ func main() {
 			createdb bool
 		)
 		// The given dir points to an existent directory, assume it's
-		// a old database for read testing.
-		if isDir(*dirflag) && fileExist(filepath.Join(*dirflag, "testing.key")) {
+		// a old database for read testing. In-memory databases are
+		// always created from scratch.
+		if cfg.Storage != bench.StorageMem && isDir(*dirflag) && fileExist(filepath.Join(*dirflag, bench.KeyFileName)) {
 			if strings.Contains(*dirflag, "filter") != strings.Contains(name, "filter") {
 				log.Printf("Skip test %s. Incompatible database", name)
 				continue
@@ -73,8 +111,10 @@ func main() {
 		} else {
 			dbdir, createdb = filepath.Join(*dirflag, "testdb-"+name), true
 		}
-		if err := os.MkdirAll(dbdir, 0755); err != nil {
-			log.Fatal("can't create keyfile dir: ", err)
+		if cfg.Storage != bench.StorageMem {
+			if err := os.MkdirAll(dbdir, 0755); err != nil {
+				log.Fatal("can't create keyfile dir: ", err)
+			}
 		}
 		if err := runTest(*logdirflag, dbdir, name, createdb, cfg); err != nil {
 			log.Printf("test %q failed: %v", name, err)
@@ -91,39 +131,37 @@ func main() {
 
 func runTest(logdir, dbdir, name string, createdb bool, cfg bench.ReadConfig) error {
 	cfg.TestName = name
+	if c, ok := tests[name].(configurer); ok {
+		c.configure(&cfg)
+	}
 	logfile, err := os.Create(filepath.Join(logdir, name+time.Now().Format(".2006-01-02-15:04:05")+".json"))
 	if err != nil {
 		return err
 	}
 	defer logfile.Close()
 
+	// Keys of in-memory databases are kept in memory too, so nothing is
+	// written to disk during the run except the log.
 	var (
-		kw    io.Writer
-		kr    io.Reader
-		reset func()
-		kfile = filepath.Join(dbdir, "testing.key")
+		keys  *bench.KeyFile
+		kfile = filepath.Join(dbdir, bench.KeyFileName)
 	)
-	if !createdb {
-		keyfile, err := os.Open(kfile)
-		if err != nil {
-			return err
-		}
-		defer keyfile.Close()
-		kr = keyfile
-	} else {
-		keyfile, err := os.Create(kfile)
-		if err != nil {
-			return err
-		}
-		defer keyfile.Close()
-		kw, kr = keyfile, keyfile
-		reset = func() {
-			keyfile.Seek(0, io.SeekStart)
-		}
+	switch {
+	case cfg.Storage == bench.StorageMem:
+		keys, err = bench.NewMemKeyFile(cfg.KeySize)
+	case !createdb:
+		keys, err = bench.OpenKeyFile(kfile, cfg.KeySize)
+	default:
+		keys, err = bench.CreateKeyFile(kfile, cfg.KeySize)
 	}
+	if err != nil {
+		return err
+	}
+	defer keys.Close()
 
 	log.Printf("== running %q", name)
-	env := bench.NewReadEnv(logfile, kr, kw, reset, cfg)
+	env := bench.NewReadEnv(logfile, keys, cfg)
+	env.Describe(dbdir, tests[name])
 	return tests[name].Benchmark(dbdir, env)
 }
 
@@ -131,6 +169,11 @@ type Benchmarker interface {
 	Benchmark(dir string, env *bench.ReadEnv) error
 }
 
+// configurer is implemented by benchmarks that modify the read workload.
+type configurer interface {
+	configure(cfg *bench.ReadConfig)
+}
+
 var tests = map[string]Benchmarker{
 	"random-read": randomRead{},
 	"random-read-filter": randomRead{Options: opt.Options{
@@ -143,6 +186,36 @@ var tests = map[string]Benchmarker{
 		BlockCacheCapacity: 100 * opt.MiB,
 		Filter:             filter.NewBloomFilter(10),
 	}},
+
+	// Concurrent variants of the random-read tests.
+	"random-read-concurrent":        concurrentRead{Benchmarker: randomRead{}, N: 8},
+	"random-read-filter-concurrent": concurrentRead{Benchmarker: randomRead{Options: opt.Options{Filter: filter.NewBloomFilter(10)}}, N: 8},
+	"random-read-bigcache-concurrent": concurrentRead{Benchmarker: randomRead{Options: opt.Options{
+		BlockCacheCapacity: 100 * opt.MiB,
+	}}, N: 8},
+	"random-read-bigcache-filter-concurrent": concurrentRead{Benchmarker: randomRead{Options: opt.Options{
+		BlockCacheCapacity: 100 * opt.MiB,
+		Filter:             filter.NewBloomFilter(10),
+	}}, N: 8},
+
+	// Reads with a background writer driving compactions.
+	"random-read-writing-1mb":  readWhileWriting{Benchmarker: randomRead{}, Rate: opt.MiB},
+	"random-read-writing-10mb": readWhileWriting{Benchmarker: randomRead{}, Rate: 10 * opt.MiB},
+	"random-read-filter-writing-10mb": readWhileWriting{Benchmarker: randomRead{Options: opt.Options{
+		Filter: filter.NewBloomFilter(10),
+	}}, Rate: 10 * opt.MiB},
+	"random-read-concurrent-writing-10mb": readWhileWriting{
+		Benchmarker: concurrentRead{Benchmarker: randomRead{}, N: 8},
+		Rate:        10 * opt.MiB,
+	},
+
+	// Iterator benchmarks.
+	"scan-100":          scanRead{N: 100},
+	"scan-1000":         scanRead{N: 1000},
+	"scan-full":         scanRead{Full: true},
+	"scan-prefix-1":     scanRead{Prefix: 1},
+	"scan-prefix-2":     scanRead{Prefix: 2},
+	"scan-100-bigcache": scanRead{N: 100, Options: opt.Options{BlockCacheCapacity: 100 * opt.MiB}},
 }
 
 func testnames() (n []string) {
@@ -153,12 +226,17 @@ func testnames() (n []string) {
 	return n
 }
 
+// openDB opens the test database with the storage configured by the flags.
+func openDB(dir string, o *opt.Options, env *bench.ReadEnv) (*bench.DB, error) {
+	return bench.OpenDB(dir, o, env.StorageConfig(), env.AddSampler)
+}
+
 type randomRead struct {
 	Options opt.Options
 }
 
 func (b randomRead) Benchmark(dir string, env *bench.ReadEnv) error {
-	db, err := leveldb.OpenFile(dir, &b.Options)
+	db, err := openDB(dir, &b.Options, env)
 	if err != nil {
 		return err
 	}
@@ -169,6 +247,7 @@ func (b randomRead) Benchmark(dir string, env *bench.ReadEnv) error {
 		}
 		return nil
 	}, func(key string) error {
+		// Lookups of absent keys must return leveldb.ErrNotFound.
 		if value, err := db.Get([]byte(key), nil); err != nil {
 			return err
 		} else {
@@ -178,6 +257,82 @@ func (b randomRead) Benchmark(dir string, env *bench.ReadEnv) error {
 	})
 }
 
+// concurrentRead wraps a benchmark, making it read from N goroutines.
+type concurrentRead struct {
+	Benchmarker
+	N int
+}
+
+func (b concurrentRead) configure(cfg *bench.ReadConfig) {
+	cfg.Readers = b.N
+}
+
+// readWhileWriting wraps a benchmark, adding a background writer which
+// writes Rate bytes/s.
+type readWhileWriting struct {
+	Benchmarker
+	Rate uint64
+}
+
+func (b readWhileWriting) configure(cfg *bench.ReadConfig) {
+	if c, ok := b.Benchmarker.(configurer); ok {
+		c.configure(cfg)
+	}
+	cfg.WriteRate = b.Rate
+}
+
+// scanRead iterates the database. Depending on the configuration, it reads N entries
+// after a random start key, all entries sharing a prefix with a random key,
+// or the whole database.
+type scanRead struct {
+	Options opt.Options
+	N       int  // number of entries per seek, zero means no limit
+	Prefix  int  // length of the prefix taken from the start key
+	Full    bool // scan the whole database
+}
+
+func (b scanRead) Benchmark(dir string, env *bench.ReadEnv) error {
+	db, err := openDB(dir, &b.Options, env)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return env.RunScan(func(key, value string, lastCall bool) error {
+		return db.Put([]byte(key), []byte(value), nil)
+	}, func(start string) error {
+		var rng *util.Range
+		switch {
+		case b.Full:
+		case b.Prefix > 0:
+			rng = util.BytesPrefix([]byte(start[:b.Prefix]))
+		default:
+			rng = &util.Range{Start: []byte(start)}
+		}
+		it := db.NewIterator(rng, nil)
+		defer it.Release()
+		entries, size := 0, 0
+		for i := 0; (b.N == 0 || i < b.N) && it.Next(); i++ {
+			entries++
+			size += len(it.Value())
+			if entries == 1024 {
+				env.ScanProgress(entries, size)
+				entries, size = 0, 0
+			}
+		}
+		env.ScanProgress(entries, size)
+		return it.Error()
+	})
+}
+
+func isReadOrder(name string) bool {
+	for _, o := range bench.ReadOrders {
+		if o == name {
+			return true
+		}
+	}
+	return false
+}
+
 func fileExist(path string) bool {
 	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
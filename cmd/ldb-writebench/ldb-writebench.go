@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"time"
 
 	bench "github.com/fjl/goleveldb-bench"
 	"github.com/syndtr/goleveldb/leveldb"
@@ -21,9 +22,16 @@ func main() {
 		sizeflag     = flag.String("size", "500mb", "total amount of value data to write")
 		datasizeflag = flag.String("valuesize", "100b", "size of each value")
 		keysizeflag  = flag.String("keysize", "32b", "size of each key")
+		emitsizeflag = flag.String("emitsize", "", "write a progress event after this amount of data (default 500kb without -emitinterval)")
+		emitintflag  = flag.Duration("emitinterval", 0, "write a progress event at this interval, also during stalls")
+		keydistflag  = flag.String("keydist", bench.KeyDistRandom, "key distribution ("+strings.Join(bench.KeyDists, ", ")+")")
+		statsflag    = flag.Duration("statsinterval", time.Second, "interval of database statistics in the log (0 to disable)")
 		dirflag      = flag.String("dir", ".", "test database directory")
 		logdirflag   = flag.String("logdir", ".", "test log output directory")
 		deletedbflag = flag.Bool("deletedb", false, "delete databases after test run")
+		storageflag  = flag.String("storage", bench.StorageFile, "storage of the test database ("+bench.StorageFile+", "+bench.StorageMem+")")
+		diskflag     = flag.String("diskprofile", "", "simulate disk performance on memory storage, implies -storage=mem ("+strings.Join(bench.DiskProfileNames(), ", ")+")")
+		countioflag  = flag.Bool("countio", false, "log I/O totals per database file type")
 
 		run []string
 		cfg bench.WriteConfig
@@ -50,6 +58,26 @@ func main() {
 	if cfg.KeySize, err = bench.ParseSize(*keysizeflag); err != nil {
 		log.Fatal("-datasize: ", err)
 	}
+	if *emitsizeflag != "" {
+		if cfg.EmitSize, err = bench.ParseSize(*emitsizeflag); err != nil {
+			log.Fatal("-emitsize: ", err)
+		}
+	}
+	cfg.EmitInterval = *emitintflag
+	cfg.KeyDist = *keydistflag
+	cfg.StatsInterval = *statsflag
+	if _, ok := bench.DiskProfiles[*diskflag]; !ok && *diskflag != "" {
+		log.Fatalf("-diskprofile: unknown disk profile %q", *diskflag)
+	}
+	cfg.DiskProfile = *diskflag
+	cfg.CountIO = *countioflag
+	if cfg.Storage = *storageflag; cfg.Storage != bench.StorageFile && cfg.Storage != bench.StorageMem {
+		log.Fatalf("-storage: unknown storage type %q", cfg.Storage)
+	}
+	if cfg.DiskProfile != "" {
+		// The simulated disk is backed by memory.
+		cfg.Storage = bench.StorageMem
+	}
 	cfg.LogPercent = true
 
 	if err := os.MkdirAll(*logdirflag, 0755); err != nil {
@@ -74,6 +102,9 @@ func main() {
 
 func runTest(logdir, dbdir, name string, cfg bench.WriteConfig) error {
 	cfg.TestName = name
+	if c, ok := tests[name].(configurer); ok {
+		c.configure(&cfg)
+	}
 	logfile, err := os.Create(filepath.Join(logdir, name+".json"))
 	if err != nil {
 		return err
@@ -81,6 +112,7 @@ func runTest(logdir, dbdir, name string, cfg bench.WriteConfig) error {
 	defer logfile.Close()
 	log.Printf("== running %q", name)
 	env := bench.NewWriteEnv(logfile, cfg)
+	env.Describe(dbdir, tests[name])
 	return tests[name].Benchmark(dbdir, env)
 }
 
@@ -88,6 +120,11 @@ type Benchmarker interface {
 	Benchmark(dir string, env *bench.WriteEnv) error
 }
 
+// configurer is implemented by benchmarks that modify the write workload.
+type configurer interface {
+	configure(cfg *bench.WriteConfig)
+}
+
 var tests = map[string]Benchmarker{
 	"nobatch":        seqWrite{},
 	"nobatch-nosync": seqWrite{Options: opt.Options{NoSync: true}},
@@ -144,6 +181,27 @@ var tests = map[string]Benchmarker{
 	},
 	"concurrent":         concurrentWrite{N: 8},
 	"concurrent-nomerge": concurrentWrite{N: 8, NoWriteMerge: true},
+
+	// Update workloads, a fraction of writes overwrites existing keys.
+	"nobatch-update-50":     updates{Benchmarker: seqWrite{}, Ratio: 0.5},
+	"batch-100kb-update-50": updates{Benchmarker: batchWrite{BatchSize: 100 * opt.KiB}, Ratio: 0.5},
+	"batch-100kb-update-90": updates{Benchmarker: batchWrite{BatchSize: 100 * opt.KiB}, Ratio: 0.9},
+	"batch-100kb-wb-512mb-cache-1gb-update-90": updates{
+		Benchmarker: batchWrite{
+			BatchSize: 100 * 1024,
+			Options: opt.Options{
+				BlockCacheCapacity: 1024 * opt.MiB,
+				WriteBuffer:        512 * opt.MiB,
+			},
+		},
+		Ratio: 0.9,
+	},
+
+	// Delete workloads, a fraction of operations deletes existing keys.
+	"nobatch-delete-25":            deletes{Benchmarker: seqWrite{}, Ratio: 0.25, Mode: bench.DeleteRandom},
+	"batch-100kb-delete-25":        deletes{Benchmarker: batchWrite{BatchSize: 100 * opt.KiB}, Ratio: 0.25, Mode: bench.DeleteRandom},
+	"batch-100kb-delete-oldest-50": deletes{Benchmarker: batchWrite{BatchSize: 100 * opt.KiB}, Ratio: 0.5, Mode: bench.DeleteOldest},
+	"concurrent-delete-oldest-50":  deletes{Benchmarker: concurrentWrite{N: 8}, Ratio: 0.5, Mode: bench.DeleteOldest},
 }
 
 func testnames() (n []string) {
@@ -154,22 +212,39 @@ func testnames() (n []string) {
 	return n
 }
 
+// openDB opens the test database with the storage configured by the flags.
+func openDB(dir string, o *opt.Options, env *bench.WriteEnv) (*bench.DB, error) {
+	return bench.OpenDB(dir, o, env.StorageConfig(), env.AddSampler)
+}
+
 type seqWrite struct {
 	Options opt.Options
 }
 
 func (b seqWrite) Benchmark(dir string, env *bench.WriteEnv) error {
-	db, err := leveldb.OpenFile(dir, &b.Options)
+	db, err := openDB(dir, &b.Options, env)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
-	return env.Run(func(key, value string, lastCall bool) error {
-		if err := db.Put([]byte(key), []byte(value), nil); err != nil {
+	return env.RunWithDeletes(func(key, value string, lastCall bool) error {
+		err := env.Measure(func() error {
+			return db.Put([]byte(key), []byte(value), nil)
+		})
+		if err != nil {
 			return err
 		}
 		env.Progress(len(value))
 		return nil
+	}, func(key string) error {
+		err := env.Measure(func() error {
+			return db.Delete([]byte(key), nil)
+		})
+		if err != nil {
+			return err
+		}
+		env.DeleteProgress(1)
+		return nil
 	})
 }
 
@@ -179,30 +254,63 @@ type batchWrite struct {
 }
 
 func (b batchWrite) Benchmark(dir string, env *bench.WriteEnv) error {
-	db, err := leveldb.OpenFile(dir, &b.Options)
+	db, err := openDB(dir, &b.Options, env)
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
 	batch := new(leveldb.Batch)
-	bsize := 0
-	return env.Run(func(key, value string, lastCall bool) error {
+	bsize, deleted := 0, 0
+	return env.RunWithDeletes(func(key, value string, lastCall bool) error {
 		batch.Put([]byte(key), []byte(value))
 		bsize += len(value)
 		if bsize >= b.BatchSize || lastCall {
-			if err := db.Write(batch, nil); err != nil {
+			err := env.Measure(func() error {
+				return db.Write(batch, nil)
+			})
+			if err != nil {
 				return err
 			}
+			env.DeleteProgress(deleted)
 			env.Progress(bsize)
-			bsize = 0
+			bsize, deleted = 0, 0
 			batch.Reset()
 		}
 		return nil
+	}, func(key string) error {
+		batch.Delete([]byte(key))
+		deleted++
+		return nil
 	})
 }
 
-type kv struct{ k, v string }
+// updates wraps a benchmark, making it overwrite existing keys.
+type updates struct {
+	Benchmarker
+	Ratio float64
+}
+
+func (b updates) configure(cfg *bench.WriteConfig) {
+	cfg.UpdateRatio = b.Ratio
+}
+
+// deletes wraps a benchmark, making it delete existing keys.
+type deletes struct {
+	Benchmarker
+	Ratio float64
+	Mode  string
+}
+
+func (b deletes) configure(cfg *bench.WriteConfig) {
+	cfg.DeleteRatio = b.Ratio
+	cfg.DeleteMode = b.Mode
+}
+
+type kv struct {
+	k, v string
+	del  bool
+}
 
 type concurrentWrite struct {
 	Options      opt.Options
@@ -211,7 +319,7 @@ type concurrentWrite struct {
 }
 
 func (b concurrentWrite) Benchmark(dir string, env *bench.WriteEnv) error {
-	db, err := leveldb.OpenFile(dir, &b.Options)
+	db, err := openDB(dir, &b.Options, env)
 	if err != nil {
 		return err
 	}
@@ -228,7 +336,20 @@ func (b concurrentWrite) Benchmark(dir string, env *bench.WriteEnv) error {
 			for {
 				select {
 				case kv := <-write:
-					if err := db.Put([]byte(kv.k), []byte(kv.v), wopt); err != nil {
+					if kv.del {
+						err := env.Measure(func() error {
+							return db.Delete([]byte(kv.k), wopt)
+						})
+						if err != nil {
+							return err
+						}
+						env.DeleteProgress(1)
+						continue
+					}
+					err := env.Measure(func() error {
+						return db.Put([]byte(kv.k), []byte(kv.v), wopt)
+					})
+					if err != nil {
 						return err
 					}
 					env.Progress(len(kv.v))
@@ -239,7 +360,7 @@ func (b concurrentWrite) Benchmark(dir string, env *bench.WriteEnv) error {
 		})
 	}
 
-	return env.Run(func(key, value string, lastCall bool) error {
+	return env.RunWithDeletes(func(key, value string, lastCall bool) error {
 		select {
 		case write <- kv{k: key, v: value}:
 		case <-ctx.Done():
@@ -250,5 +371,13 @@ func (b concurrentWrite) Benchmark(dir string, env *bench.WriteEnv) error {
 			return eg.Wait()
 		}
 		return nil
+	}, func(key string) error {
+		select {
+		case write <- kv{k: key, del: true}:
+			return nil
+		case <-ctx.Done():
+			cancel()
+			return eg.Wait()
+		}
 	})
 }
@@ -0,0 +1,207 @@
+package bench
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"gonum.org/v1/gonum/stat"
+	"gonum.org/v1/gonum/stat/distuv"
+)
+
+const (
+	bootstrapRounds = 2000
+	bootstrapSeed   = 0x1336
+)
+
+// Comparison is the result of comparing two samples of a metric.
+type Comparison struct {
+	OldN, NewN       int
+	OldMean, NewMean float64
+
+	// Delta is the relative change of the mean, (new-old)/old. The 95%
+	// confidence interval of Delta is [DeltaLow, DeltaHigh], estimated by
+	// bootstrap resampling.
+	Delta, DeltaLow, DeltaHigh float64
+
+	// P is the two-sided p-value of the Mann-Whitney U test.
+	//
+	// The confidence interval and P need at least two samples on each side.
+	// Otherwise they are NaN and the difference is not significant.
+	P float64
+}
+
+// Significant reports whether the difference is significant at level alpha.
+func (c Comparison) Significant(alpha float64) bool {
+	return c.P < alpha
+}
+
+// Compare compares two samples of a metric. The values should be independent,
+// e.g. the results of repeated runs.
+func Compare(old, new []float64) Comparison {
+	c := Comparison{
+		OldN:    len(old),
+		NewN:    len(new),
+		OldMean: stat.Mean(old, nil),
+		NewMean: stat.Mean(new, nil),
+		P:       1,
+	}
+	if len(old) == 0 || len(new) == 0 {
+		c.Delta, c.DeltaLow, c.DeltaHigh = math.NaN(), math.NaN(), math.NaN()
+		return c
+	}
+	c.Delta = c.NewMean/c.OldMean - 1
+	if len(old) < 2 || len(new) < 2 {
+		c.DeltaLow, c.DeltaHigh, c.P = math.NaN(), math.NaN(), math.NaN()
+		return c
+	}
+	c.DeltaLow, c.DeltaHigh = bootstrapDelta(old, new)
+	_, c.P = MannWhitneyU(old, new)
+	return c
+}
+
+// bootstrapDelta estimates the 95% confidence interval of the relative change
+// between the means of old and new.
+func bootstrapDelta(old, new []float64) (lo, hi float64) {
+	var (
+		r      = rand.New(rand.NewSource(bootstrapSeed))
+		deltas = make([]float64, bootstrapRounds)
+	)
+	for i := range deltas {
+		deltas[i] = resampleMean(r, new)/resampleMean(r, old) - 1
+	}
+	sort.Float64s(deltas)
+	return stat.Quantile(0.025, stat.Empirical, deltas, nil), stat.Quantile(0.975, stat.Empirical, deltas, nil)
+}
+
+func resampleMean(r *rand.Rand, x []float64) float64 {
+	var sum float64
+	for range x {
+		sum += x[r.Intn(len(x))]
+	}
+	return sum / float64(len(x))
+}
+
+// mannWhitneyExactLimit is the largest sample size for which MannWhitneyU
+// computes the exact distribution of U.
+const mannWhitneyExactLimit = 50
+
+// MannWhitneyU performs the Mann-Whitney U test on samples x and y. It returns
+// the U statistic of x and the two-sided p-value of the null hypothesis that
+// both samples come from the same distribution. For samples of up to 50 values,
+// the p-value is computed from the exact distribution of U given the ranks,
+// which also accounts for ties. Larger samples use the normal approximation
+// with tie correction.
+func MannWhitneyU(x, y []float64) (u, p float64) {
+	type value struct {
+		v   float64
+		inX bool
+	}
+	var all []value
+	for _, v := range x {
+		all = append(all, value{v, true})
+	}
+	for _, v := range y {
+		all = append(all, value{v, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	// Assign ranks, averaging over ties. Ranks are doubled so that
+	// averaged ranks are integers.
+	var (
+		ranks2 = make([]int, len(all))
+		rankX2 int
+		tieSum float64
+	)
+	for i := 0; i < len(all); {
+		j := i + 1
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		rank2 := i + j + 1
+		for k := i; k < j; k++ {
+			ranks2[k] = rank2
+			if all[k].inX {
+				rankX2 += rank2
+			}
+		}
+		t := float64(j - i)
+		tieSum += t*t*t - t
+		i = j
+	}
+
+	var (
+		nx, ny = float64(len(x)), float64(len(y))
+		n      = nx + ny
+	)
+	u = float64(rankX2)/2 - nx*(nx+1)/2
+	if nx == 0 || ny == 0 {
+		return u, 1
+	}
+	if len(x) <= mannWhitneyExactLimit && len(y) <= mannWhitneyExactLimit {
+		return u, exactRankSumP(ranks2, len(x), rankX2)
+	}
+	mu := nx * ny / 2
+	sigma := math.Sqrt(nx * ny / 12 * ((n + 1) - tieSum/(n*(n-1))))
+	if sigma == 0 {
+		return u, 1
+	}
+	z := math.Abs(u-mu) - 0.5 // continuity correction
+	if z < 0 {
+		z = 0
+	}
+	p = 2 * distuv.UnitNormal.CDF(-z/sigma)
+	if p > 1 {
+		p = 1
+	}
+	return u, p
+}
+
+// exactRankSumP returns the two-sided p-value of the rank sum w2 of a sample
+// of size k, given the doubled ranks of all values. It counts the subsets of
+// k ranks whose sum is at least as far from the mean as w2.
+func exactRankSumP(ranks2 []int, k, w2 int) float64 {
+	var total int
+	for _, r := range ranks2 {
+		total += r
+	}
+	// count[j][s] is the number of subsets of j ranks with sum s.
+	count := make([][]float64, k+1)
+	for j := range count {
+		count[j] = make([]float64, total+1)
+	}
+	count[0][0] = 1
+	for i, r := range ranks2 {
+		j := i + 1
+		if j > k {
+			j = k
+		}
+		for ; j > 0; j-- {
+			for s := total - r; s >= 0; s-- {
+				count[j][s+r] += count[j-1][s]
+			}
+		}
+	}
+
+	// The mean rank sum is k*total/n. Compare distances scaled by n to
+	// stay in integers.
+	var (
+		n        = len(ranks2)
+		dist     = abs(w2*n - k*total)
+		all, ext float64
+	)
+	for s, c := range count[k] {
+		all += c
+		if abs(s*n-k*total) >= dist {
+			ext += c
+		}
+	}
+	return ext / all
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMannWhitneyU(t *testing.T) {
+	x := []float64{1, 2, 3, 4, 5, 6, 7, 8}
+	y := []float64{9, 10, 11, 12, 13, 14, 15, 16}
+	if u, p := MannWhitneyU(x, y); u != 0 || p > 0.01 {
+		t.Errorf("disjoint samples: U = %v, p = %v", u, p)
+	}
+	if _, p := MannWhitneyU(x, x); p != 1 {
+		t.Errorf("equal samples: p = %v, want 1", p)
+	}
+}
+
+func TestMannWhitneyUExact(t *testing.T) {
+	tests := []struct {
+		x, y []float64
+		p    float64
+	}{
+		// All 20 ways to split six values into two groups of three are
+		// equally likely, and two of them are as extreme as this one.
+		{[]float64{1, 2, 3}, []float64{4, 5, 6}, 2.0 / 20},
+		{[]float64{1, 2, 3, 4, 5}, []float64{6, 7, 8, 9, 10}, 2.0 / 252},
+		{[]float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{[]float64{1, 1, 2, 3}, []float64{2, 3, 3, 4}, bruteForceP([]float64{1, 1, 2, 3}, []float64{2, 3, 3, 4})},
+		{[]float64{5, 5, 5}, []float64{5, 5}, 1},
+	}
+	for _, test := range tests {
+		if _, p := MannWhitneyU(test.x, test.y); math.Abs(p-test.p) > 1e-12 {
+			t.Errorf("MannWhitneyU(%v, %v): p = %v, want %v", test.x, test.y, p, test.p)
+		}
+	}
+}
+
+// bruteForceP computes the exact two-sided p-value of the Mann-Whitney U test
+// by trying all assignments of the values to x.
+func bruteForceP(x, y []float64) float64 {
+	var (
+		all    = append(append([]float64{}, x...), y...)
+		u0, _  = MannWhitneyU(x, y)
+		mu     = float64(len(x)*len(y)) / 2
+		splits int
+		ext    int
+	)
+	for mask := 0; mask < 1<<uint(len(all)); mask++ {
+		var sx, sy []float64
+		for i, v := range all {
+			if mask&(1<<uint(i)) != 0 {
+				sx = append(sx, v)
+			} else {
+				sy = append(sy, v)
+			}
+		}
+		if len(sx) != len(x) {
+			continue
+		}
+		splits++
+		if u, _ := MannWhitneyU(sx, sy); math.Abs(u-mu) >= math.Abs(u0-mu)-1e-9 {
+			ext++
+		}
+	}
+	return float64(ext) / float64(splits)
+}
+
+func TestCompare(t *testing.T) {
+	old := []float64{100, 102, 98, 101, 99}
+	new := []float64{120, 122, 118, 121, 119}
+	c := Compare(old, new)
+	if c.Delta < 0.19 || c.Delta > 0.21 {
+		t.Errorf("Delta = %v, want 0.2", c.Delta)
+	}
+	if c.DeltaLow > c.Delta || c.DeltaHigh < c.Delta {
+		t.Errorf("confidence interval [%v, %v] does not contain %v", c.DeltaLow, c.DeltaHigh, c.Delta)
+	}
+	if !c.Significant(0.05) {
+		t.Errorf("difference not significant, p = %v", c.P)
+	}
+}
+
+func TestCompareSingle(t *testing.T) {
+	c := Compare([]float64{100}, []float64{120, 121})
+	if c.Delta < 0.19 || c.Delta > 0.21 {
+		t.Errorf("Delta = %v, want 0.2", c.Delta)
+	}
+	if c.Significant(0.05) {
+		t.Errorf("single run is significant, p = %v", c.P)
+	}
+}
@@ -0,0 +1,62 @@
+package bench
+
+import (
+	"sync"
+	"time"
+)
+
+// emitter decides when progress events are written.
+type emitter struct {
+	size     uint64        // bytes, zero disables size-driven emission
+	interval time.Duration // zero disables time-driven emission
+}
+
+func newEmitter(size uint64, interval time.Duration) emitter {
+	if size == 0 && interval == 0 {
+		size = defaultEmitSize
+	}
+	return emitter{size, interval}
+}
+
+// due reports whether an event should be written after processing w bytes in
+// time d since the last event.
+func (e emitter) due(w uint64, d time.Duration) bool {
+	if e.interval > 0 && d >= e.interval {
+		return true
+	}
+	return e.size > 0 && w > e.size
+}
+
+// startTicker calls fn periodically, so events are written even when the
+// benchmark makes no progress. The returned function stops the ticker.
+func (e emitter) startTicker(fn func()) (stop func()) {
+	if e.interval == 0 {
+		return func() {}
+	}
+	period := e.interval / 10
+	if period < time.Millisecond {
+		period = time.Millisecond
+	}
+	var (
+		ticker = time.NewTicker(period)
+		quit   = make(chan struct{})
+		wg     sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-quit:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(quit)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,123 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestEmitterDue(t *testing.T) {
+	def := newEmitter(0, 0)
+	if def.due(defaultEmitSize, time.Hour) || !def.due(defaultEmitSize+1, 0) {
+		t.Error("default emitter should be size-driven")
+	}
+	timed := newEmitter(0, 100*time.Millisecond)
+	if timed.due(1<<40, 0) || !timed.due(0, 100*time.Millisecond) {
+		t.Error("timed emitter should be time-driven only")
+	}
+	both := newEmitter(1000, 100*time.Millisecond)
+	if !both.due(1001, 0) || !both.due(0, time.Second) || both.due(1000, time.Millisecond) {
+		t.Error("wrong result with size and interval")
+	}
+}
+
+func TestWriteEnvStallEvents(t *testing.T) {
+	var (
+		log bytes.Buffer
+		cfg = WriteConfig{Size: 100, KeySize: 32, DataSize: 10, EmitInterval: 10 * time.Millisecond}
+		env = NewWriteEnv(&log, cfg)
+	)
+	err := env.Run(func(key, value string, lastCall bool) error {
+		if lastCall {
+			time.Sleep(100 * time.Millisecond)
+		}
+		env.Progress(len(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalls := 0
+	for _, ev := range l.Events {
+		if ev.Delta == 0 {
+			stalls++
+		}
+	}
+	if stalls < 2 {
+		t.Errorf("got %d zero-progress events, want at least 2", stalls)
+	}
+}
+
+func TestStreamTick(t *testing.T) {
+	var (
+		e = newEmitter(1000, 10*time.Millisecond)
+		s = newTimedStream(OpRead, e, 0)
+	)
+	if _, ok := s.tick(20 * time.Millisecond); ok {
+		t.Fatal("idle timed stream wrote an event")
+	}
+	s.begin(20 * time.Millisecond)
+	p, ok := s.tick(35 * time.Millisecond)
+	if !ok || p.Ops != 0 || p.Duration != 15*time.Millisecond {
+		t.Fatalf("stalled operation: got %+v, %t", p, ok)
+	}
+	if _, ok := s.tick(40 * time.Millisecond); ok {
+		t.Fatal("event written before interval passed")
+	}
+	// The operation completes. Only the time after the last event counts.
+	p, ok = s.add(50*time.Millisecond, 100, 30*time.Millisecond)
+	if !ok || p.Ops != 1 || p.Delta != 100 || p.Duration != 15*time.Millisecond {
+		t.Fatalf("completed operation: got %+v, %t", p, ok)
+	}
+	if p.Hist.Max() != 30*time.Millisecond {
+		t.Errorf("latency %v, want 30ms", p.Hist.Max())
+	}
+}
+
+func TestMixedEnvStallEvents(t *testing.T) {
+	var (
+		log bytes.Buffer
+		cfg = MixedConfig{Size: 1000, KeySize: 32, DataSize: 100, Operations: 100, EmitInterval: 10 * time.Millisecond}
+		env = NewMixedEnv(&log, cfg)
+		db  = stallDB{mapDB: make(mapDB)}
+	)
+	if err := env.Run(WorkloadC, &db); err != nil {
+		t.Fatal(err)
+	}
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stalls := 0
+	for _, ev := range l.Events {
+		if ev.Op != OpRead {
+			continue
+		}
+		if ev.Ops == 0 {
+			if ev.Duration == 0 {
+				t.Errorf("stall event without duration: %+v", ev)
+			}
+			stalls++
+		}
+	}
+	if stalls < 2 {
+		t.Errorf("got %d zero-progress read events, want at least 2", stalls)
+	}
+}
+
+// stallDB is a mapDB whose 50th read is slow.
+type stallDB struct {
+	mapDB
+	reads int
+}
+
+func (db *stallDB) Get(key string) (int, error) {
+	if db.reads++; db.reads == 50 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return db.mapDB.Get(key)
+}
@@ -0,0 +1,162 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+const (
+	histSubBits = 4
+	histSub     = 1 << histSubBits
+	// histBuckets covers all durations up to 2^63ns.
+	histBuckets = (64-histSubBits-1)*histSub + 2*histSub
+)
+
+// Histogram is a latency histogram with HDR-style buckets: every power of two
+// is split into 16 linear sub-buckets, so values are recorded with a relative
+// error below 1/16. Durations below 32ns are recorded exactly.
+//
+// In JSON, only non-empty buckets are stored, as [index, count] pairs.
+type Histogram struct {
+	counts [histBuckets]uint64
+	n      uint64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// Record adds a duration to the histogram.
+func (h *Histogram) Record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	}
+	h.counts[histBucket(uint64(d))]++
+	h.n++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// Merge adds all values in other to h.
+func (h *Histogram) Merge(other *Histogram) {
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.n += other.n
+	h.sum += other.sum
+	if other.max > h.max {
+		h.max = other.max
+	}
+}
+
+// Reset removes all values.
+func (h *Histogram) Reset() {
+	*h = Histogram{}
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() uint64 { return h.n }
+
+// Sum returns the total of all recorded values.
+func (h *Histogram) Sum() time.Duration { return h.sum }
+
+// Max returns the largest recorded value.
+func (h *Histogram) Max() time.Duration { return h.max }
+
+// Mean returns the mean of all recorded values.
+func (h *Histogram) Mean() time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.n)
+}
+
+// Quantile returns the value below which the fraction q of recorded values
+// falls. The result is the midpoint of the bucket containing the quantile.
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.n == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(h.n) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			lo, hi := histBucketRange(i)
+			v := time.Duration(lo + (hi-lo)/2)
+			if v > h.max {
+				v = h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+// snapshot returns a copy of h, or nil if h is empty.
+func (h *Histogram) snapshot() *Histogram {
+	if h.n == 0 {
+		return nil
+	}
+	cpy := *h
+	return &cpy
+}
+
+// histBucket returns the bucket index of v.
+func histBucket(v uint64) int {
+	if v < 2*histSub {
+		return int(v)
+	}
+	shift := uint(bits.Len64(v)) - (histSubBits + 1)
+	return int(shift)*histSub + int(v>>shift)
+}
+
+// histBucketRange returns the smallest and largest value of bucket i.
+func histBucketRange(i int) (lo, hi uint64) {
+	if i < 2*histSub {
+		return uint64(i), uint64(i)
+	}
+	shift := uint(i/histSub - 1)
+	mantissa := uint64(i%histSub + histSub)
+	return mantissa << shift, (mantissa+1)<<shift - 1
+}
+
+type histogramJSON struct {
+	N       uint64        `json:"n"`
+	Sum     time.Duration `json:"sum"`
+	Max     time.Duration `json:"max"`
+	Buckets [][2]uint64   `json:"buckets"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	enc := histogramJSON{N: h.n, Sum: h.sum, Max: h.max, Buckets: [][2]uint64{}}
+	for i, c := range h.counts {
+		if c > 0 {
+			enc.Buckets = append(enc.Buckets, [2]uint64{uint64(i), c})
+		}
+	}
+	return json.Marshal(&enc)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (h *Histogram) UnmarshalJSON(input []byte) error {
+	var dec histogramJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	h.Reset()
+	h.n, h.sum, h.max = dec.N, dec.Sum, dec.Max
+	for _, b := range dec.Buckets {
+		if b[0] >= histBuckets {
+			return fmt.Errorf("invalid histogram bucket %d", b[0])
+		}
+		h.counts[b[0]] += b[1]
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package bench
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	prevHi := uint64(0)
+	for i := 0; i < histBuckets; i++ {
+		lo, hi := histBucketRange(i)
+		if i > 0 && lo != prevHi+1 {
+			t.Fatalf("bucket %d starts at %d, previous ended at %d", i, lo, prevHi)
+		}
+		if histBucket(lo) != i || histBucket(hi) != i {
+			t.Fatalf("bucket %d range [%d, %d] maps to %d, %d", i, lo, hi, histBucket(lo), histBucket(hi))
+		}
+		prevHi = hi
+	}
+	if prevHi != 1<<64-1 {
+		t.Fatalf("last bucket ends at %d", prevHi)
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0.5, 500 * time.Microsecond},
+		{0.9, 900 * time.Microsecond},
+		{0.99, 990 * time.Microsecond},
+		{1, 1000 * time.Microsecond},
+	}
+	for _, test := range tests {
+		got := h.Quantile(test.q)
+		if diff := float64(got-test.want) / float64(test.want); diff > 1.0/histSub || diff < -1.0/histSub {
+			t.Errorf("Quantile(%v) = %v, want %v", test.q, got, test.want)
+		}
+	}
+	if h.Max() != time.Millisecond {
+		t.Errorf("Max() = %v, want 1ms", h.Max())
+	}
+}
+
+func TestHistogramJSON(t *testing.T) {
+	var h Histogram
+	for _, d := range []time.Duration{5, 70, 70, 3 * time.Second} {
+		h.Record(d)
+	}
+	enc, err := json.Marshal(&h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec Histogram
+	if err := json.Unmarshal(enc, &dec); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h, dec) {
+		t.Errorf("decoded histogram differs: %s", enc)
+	}
+}
@@ -0,0 +1,156 @@
+package bench
+
+import (
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// storageFileTypes are the file types counted by CountingStorage. I/O of
+// other files is counted as otherFileType.
+var storageFileTypes = []storage.FileType{
+	storage.TypeManifest,
+	storage.TypeJournal,
+	storage.TypeTable,
+	storage.TypeTemp,
+}
+
+const otherFileType = "other"
+
+// IOCount contains the I/O totals of one file type.
+type IOCount struct {
+	ReadBytes  uint64    `json:"readbytes"`
+	WriteBytes uint64    `json:"writebytes"`
+	Reads      uint64    `json:"reads"`
+	Writes     uint64    `json:"writes"`
+	Opens      uint64    `json:"opens"`
+	Creates    uint64    `json:"creates"`
+	Syncs      uint64    `json:"syncs"`
+	SyncTime   Histogram `json:"synctime"` // latency of sync calls
+}
+
+type ioCounter struct {
+	mu sync.Mutex
+	IOCount
+}
+
+// CountingStorage is a storage.Storage which counts I/O per file type.
+// It implements Sampler, adding the totals to stats records.
+type CountingStorage struct {
+	storage.Storage
+	counters [5]ioCounter // storageFileTypes, then other
+}
+
+// NewCountingStorage wraps s.
+func NewCountingStorage(s storage.Storage) *CountingStorage {
+	return &CountingStorage{Storage: s}
+}
+
+func (s *CountingStorage) counter(ft storage.FileType) *ioCounter {
+	for i, t := range storageFileTypes {
+		if t == ft {
+			return &s.counters[i]
+		}
+	}
+	return &s.counters[len(s.counters)-1]
+}
+
+// Open implements storage.Storage.
+func (s *CountingStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	c := s.counter(fd.Type)
+	c.mu.Lock()
+	c.Opens++
+	c.mu.Unlock()
+	return &countingReader{r, c}, nil
+}
+
+// Create implements storage.Storage.
+func (s *CountingStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	c := s.counter(fd.Type)
+	c.mu.Lock()
+	c.Creates++
+	c.mu.Unlock()
+	return &countingWriter{w, c}, nil
+}
+
+// Counts returns the I/O totals, keyed by file type. Files of unknown type are
+// counted as "other".
+func (s *CountingStorage) Counts() map[string]*IOCount {
+	counts := make(map[string]*IOCount, len(s.counters))
+	for i := range s.counters {
+		name := otherFileType
+		if i < len(storageFileTypes) {
+			name = storageFileTypes[i].String()
+		}
+		c := &s.counters[i]
+		c.mu.Lock()
+		cpy := c.IOCount
+		c.mu.Unlock()
+		counts[name] = &cpy
+	}
+	return counts
+}
+
+// Sample implements Sampler.
+func (s *CountingStorage) Sample(st *Stats) error {
+	st.Storage = s.Counts()
+	return nil
+}
+
+type countingReader struct {
+	storage.Reader
+	c *ioCounter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.c.addRead(n)
+	return n, err
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	r.c.addRead(n)
+	return n, err
+}
+
+type countingWriter struct {
+	storage.Writer
+	c *ioCounter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.c.mu.Lock()
+	w.c.Writes++
+	w.c.WriteBytes += uint64(n)
+	w.c.mu.Unlock()
+	return n, err
+}
+
+func (w *countingWriter) Sync() error {
+	start := time.Now()
+	err := w.Writer.Sync()
+	d := time.Since(start)
+	w.c.mu.Lock()
+	w.c.Syncs++
+	w.c.SyncTime.Record(d)
+	w.c.mu.Unlock()
+	return err
+}
+
+func (c *ioCounter) addRead(n int) {
+	c.mu.Lock()
+	c.Reads++
+	c.ReadBytes += uint64(n)
+	c.mu.Unlock()
+}
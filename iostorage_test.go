@@ -0,0 +1,59 @@
+package bench
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestCountingStorage(t *testing.T) {
+	stor := NewCountingStorage(storage.NewMemStorage())
+	db, err := leveldb.Open(stor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 100)
+	for i := 0; i < 100; i++ {
+		if err := db.Put([]byte{byte(i)}, value, &opt.WriteOptions{Sync: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Get([]byte{1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	counts := stor.Counts()
+	journal, table := counts["journal"], counts["table"]
+	if journal.WriteBytes < 100*100 {
+		t.Errorf("journal: %d bytes written, want at least %d", journal.WriteBytes, 100*100)
+	}
+	if journal.Syncs < 100 || journal.SyncTime.Count() != journal.Syncs {
+		t.Errorf("journal: %d syncs, %d sync latencies, want at least 100", journal.Syncs, journal.SyncTime.Count())
+	}
+	if table.Creates == 0 || table.WriteBytes == 0 {
+		t.Errorf("table: %d files created, %d bytes written", table.Creates, table.WriteBytes)
+	}
+	if counts["manifest"].WriteBytes == 0 {
+		t.Error("manifest: no bytes written")
+	}
+}
+
+func TestCountingStorageOtherFiles(t *testing.T) {
+	stor := NewCountingStorage(storage.NewMemStorage())
+	stor.counter(storage.TypeAll + 1).addRead(10)
+
+	counts := stor.Counts()
+	if c := counts["other"]; c == nil || c.ReadBytes != 10 {
+		t.Errorf("other: got %+v, want 10 bytes read", c)
+	}
+	if c := counts["temp"]; c.ReadBytes != 0 {
+		t.Errorf("temp: %d bytes read, want 0", c.ReadBytes)
+	}
+}
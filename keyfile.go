@@ -0,0 +1,136 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// KeyFileName is the name of the key file in the database directory. It is not
+// counted as part of the database by NewDirSizeSampler.
+const KeyFileName = "testing.key"
+
+// KeyFile is a file of fixed-size keys. ReadEnv stores the keys of the test
+// database in it, so they can be read back in any order. The number of keys
+// is the file size divided by the key size.
+type KeyFile struct {
+	fd      keyStore
+	keySize int
+	count   uint64
+}
+
+// keyStore is the storage of a KeyFile.
+type keyStore interface {
+	io.ReaderAt
+	io.WriterAt
+	io.Closer
+}
+
+// CreateKeyFile creates an empty key file, truncating any existing file.
+func CreateKeyFile(file string, keySize uint64) (*KeyFile, error) {
+	if keySize == 0 {
+		return nil, fmt.Errorf("invalid key size %d", keySize)
+	}
+	fd, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyFile{fd: fd, keySize: int(keySize)}, nil
+}
+
+// NewMemKeyFile creates an empty key file which is kept in memory. It is used
+// with in-memory databases, which don't survive the run either.
+func NewMemKeyFile(keySize uint64) (*KeyFile, error) {
+	if keySize == 0 {
+		return nil, fmt.Errorf("invalid key size %d", keySize)
+	}
+	return &KeyFile{fd: new(memKeys), keySize: int(keySize)}, nil
+}
+
+// OpenKeyFile opens an existing key file.
+func OpenKeyFile(file string, keySize uint64) (*KeyFile, error) {
+	if keySize == 0 {
+		return nil, fmt.Errorf("invalid key size %d", keySize)
+	}
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	if info.Size()%int64(keySize) != 0 {
+		fd.Close()
+		return nil, fmt.Errorf("%s: size %d is not a multiple of key size %d", file, info.Size(), keySize)
+	}
+	return &KeyFile{fd: fd, keySize: int(keySize), count: uint64(info.Size()) / keySize}, nil
+}
+
+// Len returns the number of keys in the file.
+func (kf *KeyFile) Len() uint64 {
+	return kf.count
+}
+
+// Append adds keys to the end of the file. The length of keys must be a
+// multiple of the key size.
+func (kf *KeyFile) Append(keys []byte) error {
+	if len(keys)%kf.keySize != 0 {
+		return fmt.Errorf("invalid keys length %d", len(keys))
+	}
+	if _, err := kf.fd.WriteAt(keys, int64(kf.count)*int64(kf.keySize)); err != nil {
+		return err
+	}
+	kf.count += uint64(len(keys) / kf.keySize)
+	return nil
+}
+
+// ReadKeys reads consecutive keys starting at index i into buf. The length of
+// buf must be a multiple of the key size. It returns the number of keys read.
+func (kf *KeyFile) ReadKeys(i uint64, buf []byte) (int, error) {
+	if len(buf)%kf.keySize != 0 {
+		return 0, fmt.Errorf("invalid buffer length %d", len(buf))
+	}
+	if i >= kf.count {
+		return 0, fmt.Errorf("key index %d out of range", i)
+	}
+	if avail := (kf.count - i) * uint64(kf.keySize); uint64(len(buf)) > avail {
+		buf = buf[:avail]
+	}
+	n, err := kf.fd.ReadAt(buf, int64(i)*int64(kf.keySize))
+	return n / kf.keySize, err
+}
+
+// Close closes the file.
+func (kf *KeyFile) Close() error {
+	return kf.fd.Close()
+}
+
+// memKeys is an in-memory keyStore.
+type memKeys struct {
+	data []byte
+}
+
+func (m *memKeys) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memKeys) WriteAt(p []byte, off int64) (int, error) {
+	if end := off + int64(len(p)); end > int64(len(m.data)) {
+		m.data = append(m.data, make([]byte, end-int64(len(m.data)))...)
+	}
+	return copy(m.data[off:], p), nil
+}
+
+func (m *memKeys) Close() error {
+	m.data = nil
+	return nil
+}
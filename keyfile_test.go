@@ -0,0 +1,159 @@
+package bench
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKeySize = 8
+
+// testKeys returns n distinct keys of testKeySize bytes.
+func testKeys(n int) []byte {
+	keys := make([]byte, n*testKeySize)
+	for i := range keys {
+		keys[i] = byte(i / testKeySize)
+	}
+	return keys
+}
+
+func createTestKeyFile(t *testing.T, keys []byte) string {
+	dir, err := ioutil.TempDir("", "keyfile-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "test.key")
+	kf, err := CreateKeyFile(file, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kf.Close()
+	if err := kf.Append(keys[:3*testKeySize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := kf.Append(keys[3*testKeySize:]); err != nil {
+		t.Fatal(err)
+	}
+	if err := kf.Append(make([]byte, testKeySize+1)); err == nil {
+		t.Error("no error for misaligned append")
+	}
+	if kf.Len() != uint64(len(keys)/testKeySize) {
+		t.Errorf("Len() = %d after append, want %d", kf.Len(), len(keys)/testKeySize)
+	}
+	return file
+}
+
+func TestKeyFile(t *testing.T) {
+	keys := testKeys(10)
+	file := createTestKeyFile(t, keys)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	kf, err := OpenKeyFile(file, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kf.Close()
+	if kf.Len() != 10 {
+		t.Fatalf("Len() = %d, want 10", kf.Len())
+	}
+
+	buf := make([]byte, 4*testKeySize)
+	for _, test := range []struct {
+		index uint64
+		want  int
+	}{
+		{0, 4},
+		{6, 4},
+		{8, 2}, // only two keys left
+		{9, 1},
+	} {
+		n, err := kf.ReadKeys(test.index, buf)
+		if err != nil {
+			t.Errorf("ReadKeys(%d): %v", test.index, err)
+			continue
+		}
+		if n != test.want {
+			t.Errorf("ReadKeys(%d) read %d keys, want %d", test.index, n, test.want)
+		}
+		want := keys[test.index*testKeySize:][:n*testKeySize]
+		if !bytes.Equal(buf[:n*testKeySize], want) {
+			t.Errorf("ReadKeys(%d) = %x, want %x", test.index, buf[:n*testKeySize], want)
+		}
+	}
+	if _, err := kf.ReadKeys(10, buf); err == nil {
+		t.Error("no error for index past the end")
+	}
+	if _, err := kf.ReadKeys(0, buf[:testKeySize+1]); err == nil {
+		t.Error("no error for misaligned buffer")
+	}
+}
+
+func TestKeyFileMisaligned(t *testing.T) {
+	file := createTestKeyFile(t, testKeys(10))
+	defer os.RemoveAll(filepath.Dir(file))
+
+	if err := os.Truncate(file, 10*testKeySize-3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenKeyFile(file, testKeySize); err == nil {
+		t.Fatal("no error for file size which is not a multiple of the key size")
+	}
+	if _, err := OpenKeyFile(file, 0); err == nil {
+		t.Fatal("no error for zero key size")
+	}
+}
+
+func TestKeyFileTruncated(t *testing.T) {
+	file := createTestKeyFile(t, testKeys(10))
+	defer os.RemoveAll(filepath.Dir(file))
+
+	kf, err := OpenKeyFile(file, testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kf.Close()
+
+	// Keys past the end of the file can't be read if it shrinks after
+	// opening. The keys which are still there are returned.
+	if err := os.Truncate(file, 8*testKeySize); err != nil {
+		t.Fatal(err)
+	}
+	n, err := kf.ReadKeys(6, make([]byte, 4*testKeySize))
+	if err == nil {
+		t.Error("no error reading past the end of the truncated file")
+	}
+	if n != 2 {
+		t.Errorf("read %d keys from truncated file, want 2", n)
+	}
+}
+
+func TestMemKeyFile(t *testing.T) {
+	keys := testKeys(10)
+	kf, err := NewMemKeyFile(testKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kf.Close()
+	if err := kf.Append(keys[:4*testKeySize]); err != nil {
+		t.Fatal(err)
+	}
+	if err := kf.Append(keys[4*testKeySize:]); err != nil {
+		t.Fatal(err)
+	}
+	if kf.Len() != 10 {
+		t.Fatalf("Len() = %d, want 10", kf.Len())
+	}
+	buf := make([]byte, 4*testKeySize)
+	n, err := kf.ReadKeys(8, buf)
+	if err != nil || n != 2 {
+		t.Fatalf("ReadKeys(8) = %d, %v, want 2 keys", n, err)
+	}
+	if !bytes.Equal(buf[:2*testKeySize], keys[8*testKeySize:]) {
+		t.Errorf("ReadKeys(8) = %x, want %x", buf[:2*testKeySize], keys[8*testKeySize:])
+	}
+	if _, err := kf.ReadKeys(10, buf); err == nil {
+		t.Error("no error for index past the end")
+	}
+}
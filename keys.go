@@ -0,0 +1,241 @@
+package bench
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/rand"
+)
+
+// Key distributions supported by WriteEnv.
+const (
+	KeyDistRandom = "random"  // uniformly random bytes
+	KeyDistSeq    = "seq"     // big-endian counter, keys are written in ascending order
+	KeyDistZipf   = "zipfian" // zipfian over a bounded keyspace, hot keys are scattered
+	KeyDistLatest = "latest"  // skewed towards the most recently written keys
+	KeyDistHash   = "hash"    // SHA1 hash of a counter
+)
+
+// KeyDists lists all available key distributions.
+var KeyDists = []string{KeyDistRandom, KeyDistSeq, KeyDistZipf, KeyDistLatest, KeyDistHash}
+
+const (
+	// keySeed and poolSeed seed the key generators and keyPool. They differ
+	// from the seed of the value stream, so that random keys aren't copies
+	// of the value bytes written with them.
+	keySeed  = 0x4b6579
+	poolSeed = 0x506f6f6c
+	// maxPoolKeys is the number of keys remembered by keyPool.
+	maxPoolKeys = 1 << 20
+	// zipfS is the zipfian skew parameter. YCSB uses 0.99, but math/rand
+	// requires s > 1, so this is as close as we can get.
+	zipfS = 1.01
+)
+
+// keyGen generates keys for write benchmarks.
+type keyGen interface {
+	// next fills key with the next key.
+	next(key []byte)
+}
+
+// indexedKeyGen is a key generator that derives keys from a counter. It can
+// recreate every key it has generated so far.
+type indexedKeyGen interface {
+	keyGen
+	// keyAt fills key with the i'th generated key.
+	keyAt(key []byte, i uint64)
+}
+
+// newKeyGen creates a generator for the given distribution. keyspace is the
+// number of distinct keys for distributions that need a bounded keyspace.
+func newKeyGen(dist string, r *rand.Rand, keyspace uint64) (keyGen, error) {
+	if keyspace == 0 {
+		keyspace = 1
+	}
+	switch dist {
+	case "", KeyDistRandom:
+		return randomKeys{r}, nil
+	case KeyDistSeq:
+		return new(seqKeys), nil
+	case KeyDistHash:
+		return newHashKeys(), nil
+	case KeyDistZipf:
+		return &zipfKeys{zipf: rand.NewZipf(r, zipfS, 1, keyspace-1), hash: newHashKeys()}, nil
+	case KeyDistLatest:
+		return &latestKeys{zipf: rand.NewZipf(r, zipfS, 1, keyspace-1), hash: newHashKeys()}, nil
+	default:
+		return nil, fmt.Errorf("unknown key distribution %q", dist)
+	}
+}
+
+type randomKeys struct{ rand *rand.Rand }
+
+func (g randomKeys) next(key []byte) {
+	g.rand.Read(key)
+}
+
+// seqKeys generates keys containing a big-endian counter.
+type seqKeys struct{ n uint64 }
+
+func (g *seqKeys) next(key []byte) {
+	g.keyAt(key, g.n)
+	g.n++
+}
+
+func (g *seqKeys) keyAt(key []byte, i uint64) {
+	var enc [8]byte
+	binary.BigEndian.PutUint64(enc[:], i)
+	for j := range key {
+		key[j] = 0
+	}
+	if len(key) >= len(enc) {
+		copy(key[len(key)-len(enc):], enc[:])
+	} else {
+		copy(key, enc[len(enc)-len(key):])
+	}
+}
+
+// hashKeys generates keys by hashing a counter, like ldb-crashtest. Keys
+// longer than the hash are filled by hashing the hash again.
+type hashKeys struct {
+	n   uint64
+	h   hash.Hash
+	enc [8]byte
+	sum []byte
+}
+
+func newHashKeys() *hashKeys {
+	return &hashKeys{h: sha1.New(), sum: make([]byte, 0, sha1.Size)}
+}
+
+func (g *hashKeys) next(key []byte) {
+	g.keyAt(key, g.n)
+	g.n++
+}
+
+func (g *hashKeys) keyAt(key []byte, i uint64) {
+	binary.BigEndian.PutUint64(g.enc[:], i)
+	g.h.Reset()
+	g.h.Write(g.enc[:])
+	g.sum = g.h.Sum(g.sum[:0])
+	for n := copy(key, g.sum); n < len(key); n += copy(key[n:], g.sum) {
+		g.h.Reset()
+		g.h.Write(g.sum)
+		g.sum = g.h.Sum(g.sum[:0])
+	}
+}
+
+// zipfKeys picks keys from a bounded keyspace with zipfian distribution.
+// The rank is hashed so hot keys don't end up next to each other.
+type zipfKeys struct {
+	zipf *rand.Zipf
+	hash *hashKeys
+}
+
+func (g *zipfKeys) next(key []byte) {
+	g.hash.keyAt(key, g.zipf.Uint64())
+}
+
+// latestKeys advances a counter on every call and picks the key with zipfian
+// distribution over the counter values seen so far, favoring the newest ones.
+type latestKeys struct {
+	n    uint64
+	zipf *rand.Zipf
+	hash *hashKeys
+}
+
+func (g *latestKeys) next(key []byte) {
+	g.n++
+	g.hash.keyAt(key, g.n-1-g.zipf.Uint64()%g.n)
+}
+
+// keyPool remembers a bounded, uniformly random sample of the keys added to it.
+// It is used to find existing keys for generators which can't recreate them.
+type keyPool struct {
+	rand    *rand.Rand
+	keySize int
+	keys    []byte
+	added   uint64
+	index   map[string]int // position of each key, nil unless removal by key is enabled
+}
+
+func newKeyPool(r *rand.Rand, keySize int) *keyPool {
+	return &keyPool{rand: r, keySize: keySize}
+}
+
+// enableRemove makes the pool track the position of its keys, which is needed
+// for remove.
+func (p *keyPool) enableRemove() {
+	p.index = make(map[string]int)
+	for i := 0; i < p.len(); i++ {
+		p.index[string(p.keyAt(i))] = i
+	}
+}
+
+func (p *keyPool) keyAt(i int) []byte {
+	return p.keys[i*p.keySize : (i+1)*p.keySize]
+}
+
+func (p *keyPool) len() int {
+	if p.keySize == 0 {
+		return 0
+	}
+	return len(p.keys) / p.keySize
+}
+
+// add offers key to the pool. It uses reservoir sampling to decide whether the
+// key is kept.
+func (p *keyPool) add(key []byte) {
+	p.added++
+	if n := p.len(); n < maxPoolKeys {
+		p.keys = append(p.keys, key...)
+		if p.index != nil {
+			p.index[string(key)] = n
+		}
+		return
+	}
+	if i := int(p.rand.Int63n(int64(p.added))); i < maxPoolKeys {
+		if p.index != nil {
+			delete(p.index, string(p.keyAt(i)))
+			p.index[string(key)] = i
+		}
+		copy(p.keyAt(i), key)
+	}
+}
+
+// pick fills key with a random key from the pool and removes it from the pool
+// if remove is true. It returns false if the pool is empty.
+func (p *keyPool) pick(key []byte, remove bool) bool {
+	n := p.len()
+	if n == 0 {
+		return false
+	}
+	i := p.rand.Intn(n)
+	copy(key, p.keyAt(i))
+	if remove {
+		p.removeAt(i)
+	}
+	return true
+}
+
+// remove removes key from the pool if it is present. Removal must be enabled
+// with enableRemove.
+func (p *keyPool) remove(key []byte) {
+	if i, ok := p.index[string(key)]; ok {
+		p.removeAt(i)
+	}
+}
+
+// removeAt removes the key at position i by moving the last key there.
+func (p *keyPool) removeAt(i int) {
+	last := p.len() - 1
+	if p.index != nil {
+		delete(p.index, string(p.keyAt(i)))
+		if i != last {
+			p.index[string(p.keyAt(last))] = i
+		}
+	}
+	copy(p.keyAt(i), p.keyAt(last))
+	p.keys = p.keys[:last*p.keySize]
+}
@@ -0,0 +1,187 @@
+package bench
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"math/rand"
+	"testing"
+)
+
+func TestSeqKeys(t *testing.T) {
+	for _, size := range []int{4, 8, 32} {
+		gen, _ := newKeyGen(KeyDistSeq, nil, 0)
+		prev := make([]byte, size)
+		key := make([]byte, size)
+		gen.next(prev)
+		for i := 1; i < 1000; i++ {
+			gen.next(key)
+			if bytes.Compare(prev, key) >= 0 {
+				t.Fatalf("size %d: key %d (%x) not greater than previous (%x)", size, i, key, prev)
+			}
+			copy(prev, key)
+		}
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	gen := newHashKeys()
+	key := make([]byte, 48)
+	gen.next(key)
+
+	want := sha1.Sum(make([]byte, 8))
+	if !bytes.Equal(key[:sha1.Size], want[:]) {
+		t.Errorf("wrong first key %x, want prefix %x", key, want)
+	}
+	again := make([]byte, 48)
+	gen.keyAt(again, 0)
+	if !bytes.Equal(key, again) {
+		t.Errorf("keyAt(0) = %x, want %x", again, key)
+	}
+}
+
+func TestZipfKeysBounded(t *testing.T) {
+	const keyspace = 100
+	valid := make(map[string]bool)
+	hash := newHashKeys()
+	for i := uint64(0); i < keyspace; i++ {
+		key := make([]byte, 32)
+		hash.keyAt(key, i)
+		valid[string(key)] = true
+	}
+
+	for _, dist := range []string{KeyDistZipf, KeyDistLatest} {
+		gen, err := newKeyGen(dist, rand.New(rand.NewSource(1)), keyspace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := make([]byte, 32)
+		seen := make(map[string]int)
+		for i := 0; i < keyspace; i++ {
+			gen.next(key)
+			if !valid[string(key)] {
+				t.Fatalf("%s: key %x outside of keyspace", dist, key)
+			}
+			seen[string(key)]++
+		}
+		if len(seen) == keyspace {
+			t.Errorf("%s: no repeated keys", dist)
+		}
+	}
+}
+
+func TestKeyPool(t *testing.T) {
+	pool := newKeyPool(rand.New(rand.NewSource(1)), 4)
+	key := make([]byte, 4)
+	if pool.pick(key, false) {
+		t.Fatal("pick succeeded on empty pool")
+	}
+	added := make(map[string]bool)
+	for i := byte(0); i < 10; i++ {
+		k := []byte{i, i, i, i}
+		pool.add(k)
+		added[string(k)] = true
+	}
+	for i := 0; i < 100; i++ {
+		if !pool.pick(key, false) {
+			t.Fatal("pick failed")
+		}
+		if !added[string(key)] {
+			t.Fatalf("picked key %x was never added", key)
+		}
+	}
+}
+
+func TestKeyPoolRemove(t *testing.T) {
+	pool := newKeyPool(rand.New(rand.NewSource(1)), 1)
+	for i := byte(0); i < 10; i++ {
+		pool.add([]byte{i})
+	}
+	seen := make(map[byte]bool)
+	key := make([]byte, 1)
+	for pool.pick(key, true) {
+		if seen[key[0]] {
+			t.Fatalf("key %x picked twice", key)
+		}
+		seen[key[0]] = true
+	}
+	if len(seen) != 10 {
+		t.Fatalf("picked %d keys, want 10", len(seen))
+	}
+}
+
+func TestKeyPoolRemoveKey(t *testing.T) {
+	pool := newKeyPool(rand.New(rand.NewSource(1)), 1)
+	pool.enableRemove()
+	for i := byte(0); i < 10; i++ {
+		pool.add([]byte{i})
+	}
+	for i := byte(0); i < 10; i += 2 {
+		pool.remove([]byte{i})
+	}
+	pool.remove([]byte{100}) // not in pool
+	if pool.len() != 5 {
+		t.Fatalf("pool has %d keys after remove, want 5", pool.len())
+	}
+	key := make([]byte, 1)
+	for pool.pick(key, true) {
+		if key[0]%2 == 0 {
+			t.Fatalf("picked removed key %x", key)
+		}
+	}
+}
+
+func TestWriteEnvDeleteOldest(t *testing.T) {
+	for _, dist := range []string{KeyDistRandom, KeyDistSeq} {
+		cfg := WriteConfig{
+			Size:        10000 * 10,
+			KeySize:     8,
+			DataSize:    10,
+			KeyDist:     dist,
+			UpdateRatio: 0.5,
+			DeleteRatio: 0.3,
+			DeleteMode:  DeleteOldest,
+		}
+		var (
+			env     = NewWriteEnv(new(bytes.Buffer), cfg)
+			deleted = make(map[string]bool)
+			written = make(map[string]bool)
+		)
+		err := env.RunWithDeletes(func(key, value string, lastCall bool) error {
+			if deleted[key] {
+				t.Fatalf("%s: write of deleted key %x", dist, key)
+			}
+			written[key] = true
+			return nil
+		}, func(key string) error {
+			if !written[key] {
+				t.Fatalf("%s: delete of unwritten key %x", dist, key)
+			}
+			deleted[key] = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deleted) == 0 {
+			t.Fatalf("%s: no keys deleted", dist)
+		}
+	}
+}
+
+func TestWriteEnvRandomKeysIndependent(t *testing.T) {
+	cfg := WriteConfig{Size: 100 * 32, KeySize: 16, DataSize: 32, KeyDist: KeyDistRandom}
+	var n, same int
+	err := NewWriteEnv(new(bytes.Buffer), cfg).Run(func(key, value string, lastCall bool) error {
+		n++
+		if key == value[:len(key)] {
+			same++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same > 0 {
+		t.Errorf("%d of %d keys are copies of their value", same, n)
+	}
+}
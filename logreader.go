@@ -0,0 +1,134 @@
+package bench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// LogReader reads the records of a benchmark log one at a time. Records of
+// unknown type are skipped. Gzip-compressed logs are decompressed
+// transparently.
+//
+// The record types are *Header, *Progress, *Stats, *Annotation and *Trailer.
+// Logs written before version 1 of the schema contain progress events only.
+type LogReader struct {
+	in     *bufio.Reader
+	closer io.Closer
+	line   int
+	header *Header
+	rec    interface{}
+	err    error
+}
+
+// OpenLog opens a log file for reading.
+func OpenLog(file string) (*LogReader, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewLogReader(fd)
+	if err != nil {
+		fd.Close()
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	r.closer = fd
+	return r, nil
+}
+
+// NewLogReader creates a reader for the log in r.
+func NewLogReader(r io.Reader) (*LogReader, error) {
+	in := bufio.NewReader(r)
+	if magic, _ := in.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		in = bufio.NewReader(gz)
+	}
+	return &LogReader{in: in}, nil
+}
+
+// Next advances to the next record. It returns false at the end of the log or
+// when an error occurs.
+func (r *LogReader) Next() bool {
+	for r.err == nil {
+		line, err := r.in.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return false
+		} else if err != nil && err != io.EOF {
+			r.err = err
+			return false
+		}
+		r.line++
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			continue
+		}
+		rec, err := decodeRecord(line)
+		if err != nil {
+			r.err = fmt.Errorf("line %d: %v", r.line, err)
+			return false
+		}
+		if rec == nil {
+			continue // unknown type
+		}
+		if h, ok := rec.(*Header); ok {
+			r.header = h
+		}
+		r.rec = rec
+		return true
+	}
+	return false
+}
+
+// Record returns the current record.
+func (r *LogReader) Record() interface{} {
+	return r.rec
+}
+
+// Header returns the log header, or nil if no header has been read yet.
+func (r *LogReader) Header() *Header {
+	return r.header
+}
+
+// Err returns the error which stopped iteration.
+func (r *LogReader) Err() error {
+	return r.err
+}
+
+// Close closes the underlying file, if the reader was created by OpenLog.
+func (r *LogReader) Close() error {
+	if r.closer == nil {
+		return nil
+	}
+	return r.closer.Close()
+}
+
+func decodeRecord(line []byte) (interface{}, error) {
+	var typ struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(line, &typ); err != nil {
+		return nil, err
+	}
+	var rec interface{}
+	switch typ.Type {
+	case "", RecordProgress:
+		rec = new(Progress)
+	case RecordHeader:
+		rec = new(Header)
+	case RecordStats:
+		rec = new(Stats)
+	case RecordAnnotation:
+		rec = new(Annotation)
+	case RecordTrailer:
+		rec = new(Trailer)
+	default:
+		return nil, nil
+	}
+	return rec, json.Unmarshal(line, rec)
+}
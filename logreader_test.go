@@ -0,0 +1,87 @@
+package bench
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+const testLog = `{"type":"header","version":1,"test":"test","env":"write"}
+{"processed":1000,"delta":1000,"duration":100}
+{"type":"annotation","elapsed":150,"text":"phase"}
+{"type":"future-record","x":1}
+{"type":"progress","processed":2000,"delta":1000,"duration":100}
+{"type":"trailer","duration":200,"processed":2000}
+`
+
+func TestLogReader(t *testing.T) {
+	r, err := NewLogReader(strings.NewReader(testLog))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var types []string
+	for r.Next() {
+		switch rec := r.Record().(type) {
+		case *Header:
+			types = append(types, "header")
+		case *Progress:
+			types = append(types, "progress")
+		case *Annotation:
+			types = append(types, "annotation:"+rec.Text)
+		case *Trailer:
+			types = append(types, "trailer")
+		default:
+			t.Fatalf("unexpected record %T", rec)
+		}
+	}
+	if r.Err() != nil {
+		t.Fatal(r.Err())
+	}
+	want := "header progress annotation:phase progress trailer"
+	if got := strings.Join(types, " "); got != want {
+		t.Errorf("got records %q, want %q", got, want)
+	}
+	if r.Header() == nil || r.Header().Version != 1 {
+		t.Errorf("wrong header %+v", r.Header())
+	}
+}
+
+func TestLogReaderGzip(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(testLog))
+	gz.Close()
+
+	l, err := decodeLog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Header == nil || l.Trailer == nil || len(l.Events) != 2 || len(l.Annotations) != 1 {
+		t.Errorf("wrong log %+v", l)
+	}
+}
+
+func TestLogReaderLegacy(t *testing.T) {
+	input := `{"processed":1000,"delta":1000,"duration":100}
+{"processed":2000,"delta":1000,"duration":100}`
+	l, err := decodeLog(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Header != nil || l.Trailer != nil {
+		t.Error("legacy log has header or trailer")
+	}
+	if len(l.Events) != 2 || l.Events[1].Processed != 2000 {
+		t.Errorf("wrong events %+v", l.Events)
+	}
+}
+
+func TestLogReaderError(t *testing.T) {
+	r, _ := NewLogReader(strings.NewReader("{\"processed\":1}\n{broken\n"))
+	for r.Next() {
+	}
+	if r.Err() == nil || !strings.HasPrefix(r.Err().Error(), "line 2:") {
+		t.Errorf("wrong error %v", r.Err())
+	}
+}
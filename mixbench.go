@@ -0,0 +1,412 @@
+package bench
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Operation types of MixedEnv. These appear in the op field of progress events.
+const (
+	OpLoad            = "load"
+	OpRead            = "read"
+	OpUpdate          = "update"
+	OpInsert          = "insert"
+	OpScan            = "scan"
+	OpReadModifyWrite = "rmw"
+)
+
+// mixedOps lists the operation types of MixedEnv in the order events are
+// flushed.
+var mixedOps = []string{OpLoad, OpRead, OpUpdate, OpInsert, OpScan, OpReadModifyWrite}
+
+// Workload describes a mixed workload as the proportions of each operation type
+// and the distribution of the keys they access. KeyDist must be KeyDistRandom,
+// KeyDistZipf or KeyDistLatest.
+type Workload struct {
+	Read            float64 `json:"read"`
+	Update          float64 `json:"update"`
+	Insert          float64 `json:"insert"`
+	Scan            float64 `json:"scan"`
+	ReadModifyWrite float64 `json:"rmw"`
+	KeyDist         string  `json:"keydist"`
+	MaxScanLength   int     `json:"maxscanlength"` // scan lengths are uniform in [1, MaxScanLength]
+}
+
+// These are the YCSB core workloads.
+var (
+	WorkloadA = Workload{Read: 0.5, Update: 0.5, KeyDist: KeyDistZipf}                       // update heavy
+	WorkloadB = Workload{Read: 0.95, Update: 0.05, KeyDist: KeyDistZipf}                     // read mostly
+	WorkloadC = Workload{Read: 1, KeyDist: KeyDistZipf}                                      // read only
+	WorkloadD = Workload{Read: 0.95, Insert: 0.05, KeyDist: KeyDistLatest}                   // read latest
+	WorkloadE = Workload{Scan: 0.95, Insert: 0.05, KeyDist: KeyDistZipf, MaxScanLength: 100} // short ranges
+	WorkloadF = Workload{Read: 0.5, ReadModifyWrite: 0.5, KeyDist: KeyDistZipf}              // read-modify-write
+)
+
+// MixedDB is the database interface used by MixedEnv.
+type MixedDB interface {
+	// Put stores a value. It is used by load, insert and update operations.
+	Put(key, value string) error
+	// Get reads a value and returns its size.
+	Get(key string) (int, error)
+	// Scan reads up to n entries starting at key and returns the size of
+	// their values. Keys are not counted, like for Get.
+	Scan(start string, n int) (int, error)
+}
+
+type MixedConfig struct {
+	Size       uint64 `json:"size"`       // total size of values to load
+	KeySize    uint64 `json:"keysize"`    // size of each key
+	DataSize   uint64 `json:"datasize"`   // size of each value
+	Operations uint64 `json:"operations"` // number of operations after loading
+
+	// EmitSize is the amount of data after which a progress event is written
+	// for an operation type. EmitInterval is the time after which a progress
+	// event is written, even if no progress was made. If both are zero,
+	// events are written every 500KB.
+	EmitSize     uint64        `json:"emitsize,omitempty"`
+	EmitInterval time.Duration `json:"emitinterval,omitempty"`
+
+	// StatsInterval is the interval at which statistics of samplers added with
+	// AddSampler are logged. Zero disables periodic sampling, but a final
+	// sample is still logged at the end of the run.
+	StatsInterval time.Duration `json:"statsinterval,omitempty"`
+	// CountIO makes the database count I/O per file type.
+	CountIO bool `json:"countio,omitempty"`
+
+	LogPercent bool   `json:"-"`
+	TestName   string `json:"-"`
+}
+
+// MixedEnv runs a load phase followed by a mix of operations according to a
+// Workload. Record keys are hashes of the record index, like YCSB does.
+type MixedEnv struct {
+	cfg MixedConfig
+
+	// generating keys and values
+	key, value []byte
+	rand       *rand.Rand
+	zipf       *rand.Zipf
+	hash       *hashKeys
+	records    uint64
+	log        *json.Encoder
+	mu         sync.Mutex // protects log and streams
+	header     *Header
+	emit       emitter
+	stats      *sampling
+
+	// reporting
+	streams     map[string]*stream
+	startTime   time.Duration
+	processed   uint64
+	ops         uint64
+	done        uint64
+	lastPercent int
+}
+
+func NewMixedEnv(log io.Writer, cfg MixedConfig) *MixedEnv {
+	return &MixedEnv{
+		cfg:    cfg,
+		log:    json.NewEncoder(log),
+		header: newHeader("mixed", cfg.TestName, cfg),
+		emit:   newEmitter(cfg.EmitSize, cfg.EmitInterval),
+		stats:  &sampling{interval: cfg.StatsInterval},
+		key:    make([]byte, cfg.KeySize),
+		value:  make([]byte, cfg.DataSize),
+		hash:   newHashKeys(),
+	}
+}
+
+// Describe records the database directory and the benchmark implementation in
+// the log header. Exported fields of benchmark, including goleveldb options,
+// are logged. It must be called before Run.
+func (env *MixedEnv) Describe(dbdir string, benchmark interface{}) {
+	env.header.describe(dbdir, benchmark)
+}
+
+// AddSampler adds a sampler of statistics, which are logged periodically
+// according to StatsInterval. It must be called before Run.
+func (env *MixedEnv) AddSampler(s Sampler) {
+	env.stats.add(s)
+}
+
+// StorageConfig returns the storage of the test database for OpenDB.
+func (env *MixedEnv) StorageConfig() StorageConfig {
+	return StorageConfig{CountIO: env.cfg.CountIO}
+}
+
+// Run loads the database and then performs the configured number of operations.
+func (env *MixedEnv) Run(w Workload, db MixedDB) error {
+	env.start()
+	err := w.check()
+	if err == nil {
+		stopTicker := env.emit.startTicker(env.tick)
+		stopStats := env.stats.start(env.startTime, func(st *Stats) { env.write(st) })
+		err = env.run(w, db)
+		stopTicker()
+		stopStats()
+	}
+	env.finish(err)
+	return err
+}
+
+func (env *MixedEnv) run(w Workload, db MixedDB) error {
+	// Load phase.
+	env.Annotate("load")
+	env.openStreams(OpLoad)
+	for written := uint64(0); written < env.cfg.Size; written += env.cfg.DataSize {
+		env.prepare(OpLoad, w)
+		start := env.begin(OpLoad)
+		n, err := env.runOp(OpLoad, 0, db)
+		if err != nil {
+			return err
+		}
+		env.record(OpLoad, n, mononow()-start)
+		env.logPercentage("Loading", written+env.cfg.DataSize, env.cfg.Size)
+	}
+	env.flush()
+	if env.records == 0 {
+		return errors.New("no records loaded")
+	}
+
+	// Run phase.
+	env.Annotate("run")
+	env.openStreams(w.ops()...)
+	env.zipf = rand.NewZipf(env.rand, zipfS, 1, env.records-1)
+	for i := uint64(1); i <= env.cfg.Operations; i++ {
+		op := env.chooseOp(w)
+		scanLength := env.prepare(op, w)
+		start := env.begin(op)
+		n, err := env.runOp(op, scanLength, db)
+		if err != nil {
+			return fmt.Errorf("%s: %v", op, err)
+		}
+		env.record(op, n, mononow()-start)
+		env.logPercentage("Running", i, env.cfg.Operations)
+	}
+	env.flush()
+	return nil
+}
+
+func (w Workload) check() error {
+	switch w.KeyDist {
+	case KeyDistRandom, KeyDistZipf, KeyDistLatest:
+	default:
+		return fmt.Errorf("unsupported workload key distribution %q", w.KeyDist)
+	}
+	if w.Read+w.Update+w.Insert+w.Scan+w.ReadModifyWrite <= 0 {
+		return errors.New("workload has no operations")
+	}
+	if w.Scan > 0 && w.MaxScanLength < 1 {
+		return errors.New("workload has scans but no MaxScanLength")
+	}
+	return nil
+}
+
+// ops returns the operation types which occur in the workload.
+func (w Workload) ops() []string {
+	var ops []string
+	for _, op := range []struct {
+		name string
+		p    float64
+	}{
+		{OpRead, w.Read},
+		{OpUpdate, w.Update},
+		{OpInsert, w.Insert},
+		{OpScan, w.Scan},
+		{OpReadModifyWrite, w.ReadModifyWrite},
+	} {
+		if op.p > 0 {
+			ops = append(ops, op.name)
+		}
+	}
+	return ops
+}
+
+// chooseOp picks a random operation type according to the workload.
+func (env *MixedEnv) chooseOp(w Workload) string {
+	total := w.Read + w.Update + w.Insert + w.Scan + w.ReadModifyWrite
+	p := env.rand.Float64() * total
+	switch {
+	case p < w.Read:
+		return OpRead
+	case p < w.Read+w.Update:
+		return OpUpdate
+	case p < w.Read+w.Update+w.Insert:
+		return OpInsert
+	case p < w.Read+w.Update+w.Insert+w.Scan:
+		return OpScan
+	default:
+		return OpReadModifyWrite
+	}
+}
+
+// prepare generates the key of an operation and the value it writes, so that
+// generating them isn't timed as part of the operation. For scans, it returns
+// the number of entries to read.
+func (env *MixedEnv) prepare(op string, w Workload) (scanLength int) {
+	switch op {
+	case OpLoad, OpInsert:
+		env.hash.keyAt(env.key, env.records)
+	default:
+		env.hash.keyAt(env.key, env.chooseRecord(w))
+	}
+	switch op {
+	case OpRead:
+	case OpScan:
+		scanLength = 1 + env.rand.Intn(w.MaxScanLength)
+	default:
+		env.rand.Read(env.value)
+	}
+	return scanLength
+}
+
+// runOp performs an operation prepared by prepare and returns the number of
+// value bytes processed.
+func (env *MixedEnv) runOp(op string, scanLength int, db MixedDB) (int, error) {
+	switch op {
+	case OpRead:
+		return db.Get(string(env.key))
+	case OpScan:
+		return db.Scan(string(env.key), scanLength)
+	case OpReadModifyWrite:
+		n, err := db.Get(string(env.key))
+		if err != nil {
+			return n, err
+		}
+		m, err := env.put(db)
+		return n + m, err
+	default:
+		n, err := env.put(db)
+		if err == nil && (op == OpLoad || op == OpInsert) {
+			env.records++
+		}
+		return n, err
+	}
+}
+
+// chooseRecord picks the index of an existing record.
+func (env *MixedEnv) chooseRecord(w Workload) uint64 {
+	switch w.KeyDist {
+	case KeyDistZipf:
+		return env.zipf.Uint64()
+	case KeyDistLatest:
+		return env.records - 1 - env.zipf.Uint64()%env.records
+	default:
+		return uint64(env.rand.Int63n(int64(env.records)))
+	}
+}
+
+// put writes env.value to env.key.
+func (env *MixedEnv) put(db MixedDB) (int, error) {
+	return len(env.value), db.Put(string(env.key), string(env.value))
+}
+
+func (env *MixedEnv) start() {
+	env.rand = rand.New(rand.NewSource(0x1334))
+	env.records, env.done = 0, 0
+	env.processed, env.ops = 0, 0
+	env.streams = make(map[string]*stream)
+	env.header.write(env.log)
+	env.startTime = mononow()
+}
+
+// Annotate writes an annotation to the log.
+func (env *MixedEnv) Annotate(text string) {
+	env.write(newAnnotation(mononow()-env.startTime, text))
+}
+
+// write encodes a log record. The lock is needed because stats are
+// written by the sampler goroutine.
+func (env *MixedEnv) write(rec interface{}) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.log.Encode(rec)
+}
+
+// finish writes the log trailer.
+func (env *MixedEnv) finish(err error) {
+	t := newTrailer(mononow()-env.startTime, env.processed, err)
+	t.Ops = env.ops
+	env.write(t)
+}
+
+// openStreams creates the progress streams of a phase. The streams are timed
+// because operations of all types run on the same goroutine, so the duration
+// of their events is the time spent in operations of that type.
+func (env *MixedEnv) openStreams(ops ...string) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	now := mononow()
+	for _, op := range ops {
+		env.streams[op] = newTimedStream(op, env.emit, now)
+	}
+}
+
+// begin marks the start of an operation and returns the time.
+func (env *MixedEnv) begin(op string) time.Duration {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	now := mononow()
+	env.streams[op].begin(now)
+	return now
+}
+
+// record accounts for a single operation.
+func (env *MixedEnv) record(op string, n int, d time.Duration) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.processed += uint64(n)
+	env.ops++
+	if p, ok := env.streams[op].add(mononow(), uint64(n), d); ok {
+		env.log.Encode(&p)
+	}
+}
+
+// tick writes events for streams which are due by time, so that stalls of
+// the running operation are visible in the log.
+func (env *MixedEnv) tick() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	now := mononow()
+	for _, op := range mixedOps {
+		if s := env.streams[op]; s != nil {
+			if p, ok := s.tick(now); ok {
+				env.log.Encode(&p)
+			}
+		}
+	}
+}
+
+// flush writes events for all operations which haven't been reported yet.
+func (env *MixedEnv) flush() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	now := mononow()
+	for _, op := range mixedOps {
+		if s := env.streams[op]; s != nil {
+			if p, ok := s.flush(now); ok {
+				env.log.Encode(&p)
+			}
+		}
+	}
+}
+
+func (env *MixedEnv) logPercentage(phase string, done, total uint64) {
+	if !env.cfg.LogPercent || total == 0 {
+		return
+	}
+	if done < env.done {
+		env.lastPercent = 0 // new phase
+	}
+	env.done = done
+	pct := int((float64(done) / float64(total)) * 100)
+	if pct > env.lastPercent {
+		fmt.Printf("[%s] %3d%%  %s\n", phase, pct, env.cfg.TestName)
+		env.lastPercent = pct
+	}
+}
@@ -0,0 +1,80 @@
+package bench
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mapDB is a MixedDB for testing. It stores value sizes only.
+type mapDB map[string]int
+
+func (db mapDB) Put(key, value string) error {
+	db[key] = len(value)
+	return nil
+}
+
+func (db mapDB) Get(key string) (int, error) {
+	n, ok := db[key]
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	return n, nil
+}
+
+func (db mapDB) Scan(start string, n int) (int, error) {
+	size, ok := db[start]
+	if !ok {
+		return 0, errors.New("not found")
+	}
+	return n * size, nil
+}
+
+func TestMixedEnv(t *testing.T) {
+	workloads := map[string]Workload{
+		"a": WorkloadA, "b": WorkloadB, "c": WorkloadC,
+		"d": WorkloadD, "e": WorkloadE, "f": WorkloadF,
+	}
+	for name, w := range workloads {
+		var (
+			log bytes.Buffer
+			db  = make(mapDB)
+			cfg = MixedConfig{Size: 10000, KeySize: 32, DataSize: 100, Operations: 5000}
+			env = NewMixedEnv(&log, cfg)
+		)
+		if err := env.Run(w, db); err != nil {
+			t.Fatalf("workload %s: %v", name, err)
+		}
+
+		l, err := decodeLog(&log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			ops  = make(map[string]uint64)
+			busy time.Duration
+		)
+		for _, p := range l.Events {
+			ops[p.Op] += p.Ops
+			busy += p.Duration
+		}
+		// Event durations are the time spent in each kind of operation, so
+		// they can't add up to more than the whole run.
+		if busy > l.Trailer.Duration {
+			t.Errorf("workload %s: events took %v, run took %v", name, busy, l.Trailer.Duration)
+		}
+		if ops[OpLoad] != 100 {
+			t.Errorf("workload %s: got %d load ops, want 100", name, ops[OpLoad])
+		}
+		total := uint64(0)
+		for op, n := range ops {
+			if op != OpLoad {
+				total += n
+			}
+		}
+		if total != cfg.Operations {
+			t.Errorf("workload %s: got %d ops, want %d", name, total, cfg.Operations)
+		}
+	}
+}
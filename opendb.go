@@ -0,0 +1,92 @@
+package bench
+
+import (
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// StorageConfig selects the storage stack of a test database opened by OpenDB.
+type StorageConfig struct {
+	Storage     string // StorageFile or StorageMem, StorageFile if empty
+	DiskProfile string // simulated disk on memory storage, see DiskProfiles
+	CountIO     bool   // count I/O per file type with CountingStorage
+}
+
+// DB is a test database opened by OpenDB.
+type DB struct {
+	*leveldb.DB
+	stor storage.Storage // nil if opened with leveldb.OpenFile
+}
+
+// Close closes the database and its storage.
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.stor != nil {
+		db.stor.Close()
+	}
+	return err
+}
+
+// OpenDB opens the test database in dir and passes samplers of its statistics
+// to addSampler. On file storage, the database directory and the I/O counters
+// of the process are sampled as well. Depending on cfg, the database is opened
+// through storage.NewMemStorage, SlowStorage and CountingStorage.
+//
+// A simulated disk always uses memory storage, so that its delays are not
+// added to the time of real disk I/O.
+func OpenDB(dir string, o *opt.Options, cfg StorageConfig, addSampler func(Sampler)) (*DB, error) {
+	var mem bool
+	switch cfg.Storage {
+	case "", StorageFile:
+	case StorageMem:
+		mem = true
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", cfg.Storage)
+	}
+	profile, ok := DiskProfiles[cfg.DiskProfile]
+	if !ok && cfg.DiskProfile != "" {
+		return nil, fmt.Errorf("unknown disk profile %q", cfg.DiskProfile)
+	}
+	mem = mem || cfg.DiskProfile != ""
+
+	db := new(DB)
+	if mem || cfg.CountIO {
+		var (
+			stor storage.Storage
+			err  error
+		)
+		if mem {
+			stor = storage.NewMemStorage()
+		} else if stor, err = storage.OpenFile(dir, false); err != nil {
+			return nil, err
+		}
+		s := stor
+		if cfg.DiskProfile != "" {
+			s = NewSlowStorage(s, profile)
+		}
+		if cfg.CountIO {
+			counter := NewCountingStorage(s)
+			addSampler(counter)
+			s = counter
+		}
+		if db.DB, err = leveldb.Open(s, o); err != nil {
+			stor.Close()
+			return nil, err
+		}
+		db.stor = stor
+	} else {
+		var err error
+		if db.DB, err = leveldb.OpenFile(dir, o); err != nil {
+			return nil, err
+		}
+	}
+	addSampler(NewDBSampler(db.DB))
+	if !mem {
+		addSampler(NewDirSizeSampler(dir))
+		addSampler(NewProcIOSampler())
+	}
+	return db, nil
+}
@@ -0,0 +1,30 @@
+package bench
+
+import "testing"
+
+func TestOpenDBMem(t *testing.T) {
+	var samplers []Sampler
+	add := func(s Sampler) { samplers = append(samplers, s) }
+	db, err := OpenDB("", nil, StorageConfig{Storage: StorageMem, CountIO: true}, add)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put([]byte("k"), []byte("v"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The counting storage and the database are sampled, the directory and
+	// process I/O are not.
+	if len(samplers) != 2 {
+		t.Errorf("got %d samplers, want 2", len(samplers))
+	}
+
+	if _, err := OpenDB("", nil, StorageConfig{Storage: StorageMem, DiskProfile: "floppy"}, add); err == nil {
+		t.Error("no error for unknown disk profile")
+	}
+	if _, err := OpenDB("", nil, StorageConfig{Storage: "tape"}, add); err == nil {
+		t.Error("no error for unknown storage")
+	}
+}
@@ -0,0 +1,268 @@
+package bench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// pageSize is the unit in which torn writes reach the disk.
+const pageSize = 4096
+
+// PowerLossStorage is a storage.Storage which records how much of each database
+// file has been synced. The records are written to a state writer and survive
+// a crash of the process. After the crash, SimulatePowerLoss uses them to revert
+// the database files to what a power failure would have left on disk.
+//
+// The OS page cache persists unsynced writes when only the process is killed,
+// so this is needed to test the durability of writes without sync.
+//
+// goleveldb v1.0.0 doesn't sync a new MANIFEST before SetMeta points CURRENT to
+// it, and it creates one whenever the database is opened. With NoSync, the
+// tables written while opening aren't synced either. It relies on the sync of
+// CURRENT and the directory in SetMeta, which on filesystems with ordered data
+// mode, like ext4 by default, also commits the data written to other files.
+// PowerLossStorage models this by counting everything written before SetMeta
+// as synced. Otherwise no database could be reopened after a power loss.
+type PowerLossStorage struct {
+	storage.Storage
+	mu      sync.Mutex
+	state   io.Writer
+	synced  map[storage.FileDesc]int64
+	written map[storage.FileDesc]int64 // size of files created through the storage
+}
+
+// NewPowerLossStorage wraps s. The content of existing files counts as synced.
+func NewPowerLossStorage(s storage.Storage, state io.Writer) (*PowerLossStorage, error) {
+	ps := &PowerLossStorage{
+		Storage: s,
+		state:   state,
+		synced:  make(map[storage.FileDesc]int64),
+		written: make(map[storage.FileDesc]int64),
+	}
+	fds, err := s.List(storage.TypeAll)
+	if err != nil {
+		return nil, err
+	}
+	for _, fd := range fds {
+		size, err := storageFileSize(s, fd)
+		if err != nil {
+			return nil, err
+		}
+		if err := ps.record(fd, size); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// Create implements storage.Storage.
+func (s *PowerLossStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.record(fd, 0); err != nil {
+		w.Close()
+		return nil, err
+	}
+	s.mu.Lock()
+	s.written[fd] = 0
+	s.mu.Unlock()
+	return &powerLossWriter{Writer: w, s: s, fd: fd}, nil
+}
+
+// SetMeta implements storage.Storage. All data written before it counts as
+// synced.
+func (s *PowerLossStorage) SetMeta(fd storage.FileDesc) error {
+	if err := s.Storage.SetMeta(fd); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for fd, size := range s.written {
+		if size > s.synced[fd] {
+			if err := s.recordLocked(fd, size); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Remove implements storage.Storage.
+func (s *PowerLossStorage) Remove(fd storage.FileDesc) error {
+	if err := s.Storage.Remove(fd); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	delete(s.written, fd)
+	s.mu.Unlock()
+	return nil
+}
+
+// Rename implements storage.Storage.
+func (s *PowerLossStorage) Rename(oldfd, newfd storage.FileDesc) error {
+	if err := s.Storage.Rename(oldfd, newfd); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	size := s.synced[oldfd]
+	if n, ok := s.written[oldfd]; ok {
+		s.written[newfd] = n
+		delete(s.written, oldfd)
+	}
+	s.mu.Unlock()
+	return s.record(newfd, size)
+}
+
+// record writes the synced size of a file to the state writer.
+func (s *PowerLossStorage) record(fd storage.FileDesc, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recordLocked(fd, size)
+}
+
+// recordLocked is record for callers holding s.mu.
+func (s *PowerLossStorage) recordLocked(fd storage.FileDesc, size int64) error {
+	s.synced[fd] = size
+	_, err := fmt.Fprintf(s.state, "%d %d %d\n", fd.Type, fd.Num, size)
+	return err
+}
+
+type powerLossWriter struct {
+	storage.Writer
+	s  *PowerLossStorage
+	fd storage.FileDesc
+}
+
+func (w *powerLossWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.s.mu.Lock()
+	w.s.written[w.fd] += int64(n)
+	w.s.mu.Unlock()
+	return n, err
+}
+
+func (w *powerLossWriter) Sync() error {
+	if err := w.Writer.Sync(); err != nil {
+		return err
+	}
+	w.s.mu.Lock()
+	size := w.s.written[w.fd]
+	w.s.mu.Unlock()
+	return w.s.record(w.fd, size)
+}
+
+// SimulatePowerLoss reverts the files in s to the state after a power failure,
+// according to the records written by PowerLossStorage. Unsynced data of each
+// file is discarded. If tear is true, a random part of it survives instead,
+// with random pages zeroed as if they had not been written back. Files without
+// record lose all of their content. It returns the number of bytes lost.
+func SimulatePowerLoss(s storage.Storage, state io.Reader, tear bool, rnd *rand.Rand) (lost int64, err error) {
+	synced, err := readSyncRecords(state)
+	if err != nil {
+		return 0, err
+	}
+	fds, err := s.List(storage.TypeAll)
+	if err != nil {
+		return 0, err
+	}
+	for _, fd := range fds {
+		n, err := revertFile(s, fd, synced[fd], tear, rnd)
+		if err != nil {
+			return lost, fmt.Errorf("%v: %v", fd, err)
+		}
+		lost += n
+	}
+	return lost, nil
+}
+
+// revertFile drops or tears the unsynced data of a file.
+func revertFile(s storage.Storage, fd storage.FileDesc, synced int64, tear bool, rnd *rand.Rand) (int64, error) {
+	size, err := storageFileSize(s, fd)
+	if err != nil || size <= synced {
+		return 0, err
+	}
+	data, err := readStorageFile(s, fd)
+	if err != nil {
+		return 0, err
+	}
+	keep, zeroed := synced, int64(0)
+	if tear {
+		keep += rnd.Int63n(size - synced + 1)
+		for page := synced - synced%pageSize; page < keep; page += pageSize {
+			if rnd.Intn(2) == 0 {
+				continue
+			}
+			start, end := page, page+pageSize
+			if start < synced {
+				start = synced
+			}
+			if end > keep {
+				end = keep
+			}
+			for i := start; i < end; i++ {
+				data[i] = 0
+			}
+			zeroed += end - start
+		}
+	}
+
+	w, err := s.Create(fd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.Write(data[:keep]); err != nil {
+		w.Close()
+		return 0, err
+	}
+	if err := w.Sync(); err != nil {
+		w.Close()
+		return 0, err
+	}
+	return size - keep + zeroed, w.Close()
+}
+
+// readSyncRecords reads the synced size of files from the state written by
+// PowerLossStorage. Later records replace earlier ones.
+func readSyncRecords(state io.Reader) (map[storage.FileDesc]int64, error) {
+	synced := make(map[storage.FileDesc]int64)
+	scanner := bufio.NewScanner(state)
+	for line := 1; scanner.Scan(); line++ {
+		var (
+			ft   int
+			fd   storage.FileDesc
+			size int64
+		)
+		if _, err := fmt.Sscan(scanner.Text(), &ft, &fd.Num, &size); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		fd.Type = storage.FileType(ft)
+		synced[fd] = size
+	}
+	return synced, scanner.Err()
+}
+
+func readStorageFile(s storage.Storage, fd storage.FileDesc) ([]byte, error) {
+	r, err := s.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func storageFileSize(s storage.Storage, fd storage.FileDesc) (int64, error) {
+	r, err := s.Open(fd)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return r.Seek(0, io.SeekEnd)
+}
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestPowerLossStorage(t *testing.T) {
+	const synced, unsynced = 100, 100
+	for _, tear := range []bool{false, true} {
+		var (
+			mem   = storage.NewMemStorage()
+			state bytes.Buffer
+		)
+		ps, err := NewPowerLossStorage(mem, &state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := leveldb.Open(ps, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := make([]byte, 100)
+		for i := 0; i < synced+unsynced; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := db.Put(key, value, &opt.WriteOptions{Sync: i < synced}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		db.Close()
+
+		lost, err := SimulatePowerLoss(mem, &state, tear, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tear && lost == 0 {
+			t.Error("no data lost")
+		}
+		db, err = leveldb.Open(mem, nil)
+		if err != nil {
+			t.Fatalf("tear=%t: can't reopen: %v", tear, err)
+		}
+		n := 0
+		it := db.NewIterator(nil, nil)
+		for it.Next() {
+			if binary.BigEndian.Uint64(it.Key()) != uint64(n) {
+				t.Errorf("tear=%t: unexpected key %x at position %d", tear, it.Key(), n)
+				break
+			}
+			n++
+		}
+		it.Release()
+		db.Close()
+
+		if n < synced || !tear && n != synced {
+			t.Errorf("tear=%t: %d keys after power loss, %d were synced", tear, n, synced)
+		}
+	}
+}
+
+// This test reopens an existing database through PowerLossStorage, as
+// ldb-crashtest does in every cycle after the first. With NoSync, the writes
+// after reopening may be lost, but the database must open.
+func TestPowerLossStorageReopen(t *testing.T) {
+	for _, sync := range []bool{true, false} {
+		testPowerLossStorageReopen(t, sync)
+	}
+}
+
+func testPowerLossStorageReopen(t *testing.T, sync bool) {
+	var (
+		mem   = storage.NewMemStorage()
+		value = make([]byte, 100)
+	)
+	put := func(db *leveldb.DB, from, to int) {
+		for i := from; i < to; i++ {
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, uint64(i))
+			if err := db.Put(key, value, &opt.WriteOptions{Sync: sync}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	db, err := leveldb.Open(mem, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(db, 0, 100)
+	db.Close()
+
+	// Existing files count as synced.
+	var state bytes.Buffer
+	ps, err := NewPowerLossStorage(mem, &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := make(map[storage.FileDesc]int64)
+	fds, _ := mem.List(storage.TypeAll)
+	for _, fd := range fds {
+		if sizes[fd], err = storageFileSize(mem, fd); err != nil {
+			t.Fatal(err)
+		}
+		if ps.synced[fd] != sizes[fd] {
+			t.Errorf("sync=%t: %v: %d bytes synced, want %d", sync, fd, ps.synced[fd], sizes[fd])
+		}
+	}
+
+	// Opening recovers the journal into a table, which isn't synced with
+	// NoSync, and writes a new MANIFEST. Both count as synced at SetMeta.
+	db, err = leveldb.Open(ps, &opt.Options{NoSync: !sync})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(db, 100, 200)
+	db.Close()
+	lost, err := SimulatePowerLoss(mem, &state, false, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sync && lost == 0 {
+		t.Error("sync=false: no data lost")
+	}
+	// Files which existed before reopening are not reverted.
+	for fd, size := range sizes {
+		if now, err := storageFileSize(mem, fd); err == nil && now != size {
+			t.Errorf("sync=%t: %v: size %d after power loss, want %d", sync, fd, now, size)
+		}
+	}
+
+	db, err = leveldb.Open(mem, nil)
+	if err != nil {
+		t.Fatalf("sync=%t: can't reopen after power loss: %v", sync, err)
+	}
+	defer db.Close()
+	n := 0
+	it := db.NewIterator(nil, nil)
+	for it.Next() {
+		if binary.BigEndian.Uint64(it.Key()) != uint64(n) {
+			t.Errorf("sync=%t: unexpected key %x at position %d", sync, it.Key(), n)
+			break
+		}
+		n++
+	}
+	it.Release()
+	switch {
+	case sync && n != 200:
+		t.Errorf("sync=true: %d keys after power loss, want 200", n)
+	case !sync && n != 100:
+		t.Errorf("sync=false: %d keys after power loss, want the 100 written before reopening", n)
+	}
+}
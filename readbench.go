@@ -7,12 +7,51 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Operation types of ReadEnv progress events. Events for reads of existing
+// keys have no operation type.
+const (
+	OpMiss  = "miss"  // lookups of absent keys
+	OpWrite = "write" // background writes during the read stage
 )
 
 type ReadConfig struct {
 	Size     uint64 `json:"size"`     // testing dataset size(pre-constructed)
 	KeySize  uint64 `json:"keysize"`  // size of each testing key
 	DataSize uint64 `json:"datasize"` // size of each testing value
+	Order    string `json:"order"`    // read order, one of ReadOrders
+
+	// MissRatio is the fraction of reads which look up keys that are
+	// guaranteed to be absent. Absent keys are stored keys with an extra
+	// zero byte appended, so they fall into the key range of existing tables.
+	MissRatio float64 `json:"missratio"`
+	// Readers is the number of goroutines reading concurrently.
+	Readers int `json:"readers"`
+	// WriteRate is the rate in bytes/s at which a background writer adds new
+	// random entries while reading. Zero disables the writer.
+	WriteRate uint64 `json:"writerate"`
+
+	// EmitSize is the amount of data after which a progress event is written.
+	// EmitInterval is the time after which a progress event is written, even if
+	// no progress was made. If both are zero, events are written every 500KB.
+	EmitSize     uint64        `json:"emitsize,omitempty"`
+	EmitInterval time.Duration `json:"emitinterval,omitempty"`
+
+	// StatsInterval is the interval at which statistics of samplers added with
+	// AddSampler are logged. Zero disables periodic sampling, but a final
+	// sample is still logged at the end of the run.
+	StatsInterval time.Duration `json:"statsinterval,omitempty"`
+	// DiskProfile is the name of the simulated disk the database is opened on,
+	// see DiskProfiles. Simulated disks are backed by memory, so Storage
+	// should be StorageMem.
+	DiskProfile string `json:"diskprofile,omitempty"`
+	// Storage is the storage type of the database, StorageFile if empty.
+	Storage string `json:"storage,omitempty"`
+	// CountIO makes the database count I/O per file type.
+	CountIO bool `json:"countio,omitempty"`
 
 	LogPercent bool   `json:"-"`
 	TestName   string `json:"-"`
@@ -25,43 +64,194 @@ type ReadEnv struct {
 	key, value []byte
 	rand       *rand.Rand
 	log        *json.Encoder
-	kw         io.Writer
-	kr         io.Reader
-	resetKey   func()
-	keych      chan [][]byte
+	keys       *KeyFile
+	keych      chan []byte
+	keyErr     error
+	header     *Header
+	emit       emitter
+	stats      *sampling
 
 	// reporting
-	mu                  sync.Mutex
-	startTime, lastTime time.Duration
-	read, lastRead      uint64
-	lastReadPercent     int
+	mu                   sync.Mutex
+	startTime, lastTime  time.Duration
+	read, lastRead       uint64
+	lastReadPercent      int
+	hits, misses         Histogram // since last event
+	entries              uint64    // scanned entries since last event
+	scan                 bool      // reads are scans, misses are not logged
+	writes               *stream   // background writes
+	totalHits, totalMiss Histogram
+	totalEntries         uint64
 
 	written, lastWritten uint64
 	lastWrittenPercent   int
 }
 
-func NewReadEnv(log io.Writer, kr io.Reader, kw io.Writer, resetKey func(), cfg ReadConfig) *ReadEnv {
+// NewReadEnv creates a read benchmark environment. If the key file is empty,
+// Run constructs the test dataset before reading.
+func NewReadEnv(log io.Writer, keys *KeyFile, cfg ReadConfig) *ReadEnv {
 	return &ReadEnv{
-		cfg:      cfg,
-		log:      json.NewEncoder(log),
-		kr:       kr,
-		kw:       kw,
-		resetKey: resetKey,
-		key:      make([]byte, cfg.KeySize),
-		value:    make([]byte, cfg.DataSize),
-		keych:    make(chan [][]byte, 100),
+		cfg:    cfg,
+		log:    json.NewEncoder(log),
+		header: newHeader("read", cfg.TestName, cfg),
+		emit:   newEmitter(cfg.EmitSize, cfg.EmitInterval),
+		stats:  &sampling{interval: cfg.StatsInterval},
+		keys:   keys,
+		key:    make([]byte, cfg.KeySize),
+		value:  make([]byte, cfg.DataSize),
+		keych:  make(chan []byte, 100),
 	}
 }
 
-// Run calls write repeatedly with random keys and values.
-// The write function should perform a database write and call LegacyWriteProgress when
-// data has actually been flushed to disk.
+// Describe records the database directory and the benchmark implementation in
+// the log header. Exported fields of benchmark, including goleveldb options,
+// are logged. It must be called before Run.
+func (env *ReadEnv) Describe(dbdir string, benchmark interface{}) {
+	env.header.describe(dbdir, benchmark)
+}
+
+// AddSampler adds a sampler of statistics, which are logged periodically
+// according to StatsInterval. It must be called before Run.
+func (env *ReadEnv) AddSampler(s Sampler) {
+	env.stats.add(s)
+}
+
+// StorageConfig returns the storage of the test database for OpenDB.
+func (env *ReadEnv) StorageConfig() StorageConfig {
+	return StorageConfig{Storage: env.cfg.Storage, DiskProfile: env.cfg.DiskProfile, CountIO: env.cfg.CountIO}
+}
+
+// Run calls write repeatedly with random keys and values to construct the
+// test dataset, then calls read with the stored keys in the configured order.
+// The write function should perform a database write. The read function should
+// perform a database read and call Progress.
 func (env *ReadEnv) Run(write func(key, value string, lastCall bool) error, read func(key string) error) error {
 	env.start()
+	stopStats := env.stats.start(env.startTime, env.writeStats)
+	err := env.run(write, read)
+	stopStats()
+	env.finish(err)
+	return err
+}
+
+func (env *ReadEnv) run(write func(key, value string, lastCall bool) error, read func(key string) error) error {
+	if err := env.load(write); err != nil {
+		return err
+	}
+	err := env.readKeys(write, func(key []byte) (bool, error) {
+		miss := len(key) > int(env.cfg.KeySize)
+		start := mononow()
+		err := read(string(key))
+		elapsed := mononow() - start
+		if miss {
+			if err == nil {
+				err = fmt.Errorf("found absent key %x", key)
+			} else if err == leveldb.ErrNotFound {
+				err = nil
+			}
+		}
+		if err != nil {
+			return true, err
+		}
+		env.recordRead(miss, elapsed)
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+	env.logReadSummary()
+	return nil
+}
+
+// RunScan constructs the test dataset like Run, then calls scan with start keys
+// in the configured read order until the size of the dataset has been read.
+// The scan function should iterate the database and call ScanProgress.
+// Progress events count scans as operations, so their latency is the latency
+// of whole scans, and report the entries read separately.
+func (env *ReadEnv) RunScan(write func(key, value string, lastCall bool) error, scan func(start string) error) error {
+	env.start()
+	stopStats := env.stats.start(env.startTime, env.writeStats)
+	err := env.runScan(write, scan)
+	stopStats()
+	env.finish(err)
+	return err
+}
+
+func (env *ReadEnv) runScan(write func(key, value string, lastCall bool) error, scan func(start string) error) error {
+	if err := env.load(write); err != nil {
+		return err
+	}
+	env.scan = true
+	return env.readKeys(write, func(key []byte) (bool, error) {
+		start := mononow()
+		if err := scan(string(key[:env.cfg.KeySize])); err != nil {
+			return true, err
+		}
+		elapsed := mononow() - start
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		env.hits.Record(elapsed)
+		env.totalHits.Record(elapsed)
+		return env.read >= env.cfg.Size, nil
+	})
+}
+
+// load constructs the test dataset if the key file is empty.
+func (env *ReadEnv) load(write func(key, value string, lastCall bool) error) error {
+	if env.keys.Len() > 0 {
+		return nil
+	}
+	env.Annotate("load")
+
+	var (
+		err     error
+		keypool []byte
+		wg      sync.WaitGroup
+	)
+	wg.Add(1)
+	go env.writeKey(&wg)
+	for {
+		env.rand.Read(env.key)
+		env.rand.Read(env.value)
+
+		env.written += env.cfg.DataSize
+		end := env.written >= env.cfg.Size
+		err = write(string(env.key), string(env.value), end)
+		if err != nil || end {
+			if err == nil {
+				keypool = append(keypool, env.key...)
+			}
+			if len(keypool) > 0 {
+				env.keych <- keypool
+			}
+			close(env.keych)
+			break
+		}
+		keypool = append(keypool, env.key...)
+		if len(keypool) > 1024*len(env.key) {
+			env.keych <- keypool
+			keypool = make([]byte, 0, len(keypool))
+		}
+		env.logWritePercentage()
+	}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return env.keyErr
+}
+
+// readKeys calls fn with the stored keys in the configured order until
+// fn returns true or all keys have been read. If multiple readers are
+// configured, fn is called concurrently. If a write rate is configured,
+// write is called from a background goroutine at the same time.
+func (env *ReadEnv) readKeys(write func(key, value string, lastCall bool) error, fn func(key []byte) (bool, error)) error {
+	source, err := newIndexSource(env.cfg.Order, env.rand, env.keys.Len())
+	if err != nil {
+		return err
+	}
 
 	var (
-		err      error
-		keypool  [][]byte
 		wg       sync.WaitGroup
 		shutdown = make(chan struct{})
 		result   = make(chan [][]byte, 100)
@@ -71,122 +261,342 @@ func (env *ReadEnv) Run(write func(key, value string, lastCall bool) error, read
 		wg.Wait()
 	}()
 
-	// Stage one, construct the test dataset
-	if env.kw != nil {
+	env.mu.Lock()
+	env.lastTime = mononow()
+	env.log.Encode(newAnnotation(env.lastTime-env.startTime, "read"))
+	// Sample stats at the start of the read phase, so that amplification
+	// doesn't include the load.
+	if st := env.stats.sample(env.lastTime-env.startTime, false); st != nil {
+		env.log.Encode(st)
+	}
+	env.mu.Unlock()
+	stopTicker := env.emit.startTicker(func() {
+		env.progress(0, 0)
+		env.tickWrites()
+	})
+	defer stopTicker()
+	wg.Add(1)
+	go env.readKey(source, result, shutdown, &wg)
+
+	var (
+		readers  = env.cfg.Readers
+		rwg      sync.WaitGroup
+		stopOnce sync.Once
+		stopped  = make(chan struct{})
+		stopErr  error
+	)
+	if readers < 1 {
+		readers = 1
+	}
+	stop := func(err error) {
+		stopOnce.Do(func() {
+			stopErr = err
+			close(stopped)
+		})
+	}
+	if env.cfg.WriteRate > 0 {
+		env.mu.Lock()
+		env.writes = newStream(OpWrite, env.emit, mononow())
+		env.mu.Unlock()
 		wg.Add(1)
-		go env.writeKey(&wg)
-	stageOne:
-		for {
-			env.rand.Read(env.key)
-			env.rand.Read(env.value)
-
-			env.written += env.cfg.DataSize
-			end := env.written >= env.cfg.Size
-			err = write(string(env.key), string(env.value), end)
-			if err != nil || end {
-				if err == nil {
-					keypool = append(keypool, copyBytes(env.key))
+		go func() {
+			defer wg.Done()
+			if err := env.backgroundWrite(write, stopped); err != nil {
+				stop(err)
+			}
+		}()
+	}
+	for i := 0; i < readers; i++ {
+		rwg.Add(1)
+		go func() {
+			defer rwg.Done()
+			for keybatch := range result {
+				for _, key := range keybatch {
+					if done, err := fn(key); done {
+						stop(err)
+						return
+					}
 				}
-				if len(keypool) > 0 {
-					env.keych <- keypool
+				select {
+				case <-stopped:
+					return
+				default:
 				}
-				close(env.keych)
-				break stageOne
-			}
-			keypool = append(keypool, copyBytes(env.key))
-			if len(keypool) > 1024 {
-				env.keych <- keypool
-				keypool = make([][]byte, 0)
 			}
-			env.logWritePercentage()
+		}()
+	}
+	rwg.Wait()
+	stop(env.keyErr)
+	return stopErr
+}
+
+// backgroundWrite writes new random entries at the configured rate until
+// stop is closed.
+func (env *ReadEnv) backgroundWrite(write func(key, value string, lastCall bool) error, stop chan struct{}) error {
+	var (
+		r       = rand.New(rand.NewSource(0x1335))
+		key     = make([]byte, env.cfg.KeySize)
+		value   = make([]byte, env.cfg.DataSize)
+		start   = time.Now()
+		written uint64
+	)
+	defer env.flushWrites()
+	for {
+		select {
+		case <-stop:
+			return nil
+		default:
 		}
-		if err != nil {
-			return err
+		r.Read(key)
+		r.Read(value)
+		callStart := mononow()
+		if err := write(string(key), string(value), false); err != nil {
+			return fmt.Errorf("background write: %v", err)
 		}
-	}
+		written += uint64(len(value))
+		env.recordWrite(len(value), mononow()-callStart)
 
-	// Stage two, read bench
-	wg.Add(1)
-	go env.readKey(result, shutdown, &wg)
-
-stageTwo:
-	for keybatch := range result {
-		for _, key := range keybatch {
-			err = read(string(key))
-			if err != nil {
-				break stageTwo
+		// Wait if ahead of schedule.
+		due := time.Duration(float64(written) / float64(env.cfg.WriteRate) * float64(time.Second))
+		if ahead := due - time.Since(start); ahead > time.Millisecond {
+			select {
+			case <-time.After(ahead):
+			case <-stop:
+				return nil
 			}
 		}
 	}
-	if err != nil {
-		return err
-	}
-	return nil
 }
 
 func (env *ReadEnv) writeKey(wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for batchKeys := range env.keych {
-		var buffer []byte
-		for _, key := range batchKeys {
-			buffer = append(buffer, key...)
+	for keys := range env.keych {
+		if env.keyErr != nil {
+			continue
 		}
-		if _, err := env.kw.Write(buffer); err != nil {
-			panic(fmt.Sprintf("failed to write keys %v", err))
+		if err := env.keys.Append(keys); err != nil {
+			env.keyErr = fmt.Errorf("failed to write keys: %v", err)
 		}
 	}
 }
 
-func (env *ReadEnv) readKey(result chan [][]byte, shutdown chan struct{}, wg *sync.WaitGroup) {
+// readKey sends batches of keys in the order given by source. It reads as
+// many keys as there are in the key file.
+func (env *ReadEnv) readKey(source indexSource, result chan [][]byte, shutdown chan struct{}, wg *sync.WaitGroup) {
 	defer wg.Done()
+	defer close(result)
 
-	var buffer = make([]byte, env.cfg.KeySize*1024)
-	if env.resetKey != nil {
-		env.resetKey()
-	}
-	for {
-		read, err := env.kr.Read(buffer)
-		if read == 0 {
-			close(result)
-			return
+	var (
+		ks     = int(env.cfg.KeySize)
+		count  = env.keys.Len()
+		buffer = make([]byte, ks*1024)
+	)
+	for done := uint64(0); done < count; {
+		n := len(buffer) / ks
+		if uint64(n) > count-done {
+			n = int(count - done)
+		}
+		if _, ok := source.(*seqIndex); ok {
+			// Keys are consecutive, read them all at once.
+			if _, err := env.keys.ReadKeys(done, buffer[:n*ks]); err != nil {
+				env.keyErr = fmt.Errorf("failed to read keys: %v", err)
+				return
+			}
+		} else {
+			for i := 0; i < n; i++ {
+				if _, err := env.keys.ReadKeys(source.next(), buffer[i*ks:(i+1)*ks]); err != nil {
+					env.keyErr = fmt.Errorf("failed to read keys: %v", err)
+					return
+				}
+			}
 		}
-		var batchKey = make([][]byte, read/int(env.cfg.KeySize))
-		for i := 0; i+int(env.cfg.KeySize) <= read; i += int(env.cfg.KeySize) {
-			batchKey[i/int(env.cfg.KeySize)] = copyBytes(buffer[i : i+int(env.cfg.KeySize)])
+		var batchKey = make([][]byte, n)
+		for i := range batchKey {
+			batchKey[i] = copyBytes(buffer[i*ks : (i+1)*ks])
+			if env.cfg.MissRatio > 0 && env.rand.Float64() < env.cfg.MissRatio {
+				batchKey[i] = append(batchKey[i], 0)
+			}
 		}
 		select {
 		case result <- batchKey:
 		case <-shutdown:
 			return
 		}
-		if err != nil {
-			close(result)
-			return
-		}
+		done += uint64(n)
 	}
 }
 
 func (env *ReadEnv) start() {
 	env.rand = rand.New(rand.NewSource(0x1334))
+	env.header.write(env.log)
 	env.startTime = mononow()
 	env.lastTime = env.startTime
 }
 
+// Annotate writes an annotation to the log.
+func (env *ReadEnv) Annotate(text string) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.log.Encode(newAnnotation(mononow()-env.startTime, text))
+}
+
+func (env *ReadEnv) writeStats(st *Stats) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.log.Encode(st)
+}
+
+// finish writes the log trailer.
+func (env *ReadEnv) finish(err error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.pending() {
+		env.writeEvents(mononow())
+	}
+	t := newTrailer(mononow()-env.startTime, env.read, err)
+	t.Ops = env.totalHits.Count() + env.totalMiss.Count()
+	t.Entries = env.totalEntries
+	t.MissCost = env.missCost()
+	env.log.Encode(t)
+}
+
 // Progress writes a JSON progress event to the environment's output writer.
 func (env *ReadEnv) Progress(w int) {
-	now := mononow()
+	env.progress(w, 0)
+}
+
+// ScanProgress records n entries with a total value size of w read by an iterator.
+func (env *ReadEnv) ScanProgress(n, w int) {
+	env.progress(w, n)
+}
+
+func (env *ReadEnv) progress(w, entries int) {
 	env.mu.Lock()
 	defer env.mu.Unlock()
 	env.read += uint64(w)
+	env.entries += uint64(entries)
+	env.emitIfDue()
+}
+
+// emitIfDue writes progress events if enough reads have been done or enough
+// time has passed since the last event. A miss counts like a read of one value,
+// so events are also written when all lookups are misses.
+func (env *ReadEnv) emitIfDue() {
+	// Take the time while holding the lock, so events from concurrent
+	// readers are ordered.
+	now := mononow()
+	dw := env.read - env.lastRead + env.misses.Count()*env.cfg.DataSize
+	if env.emit.due(dw, now-env.lastTime) {
+		env.writeEvents(now)
+	}
+}
+
+// pending reports whether any reads have not been reported yet.
+func (env *ReadEnv) pending() bool {
+	return env.read > env.lastRead || env.entries > 0 || env.hits.Count() > 0 || env.misses.Count() > 0
+}
+
+// writeEvents writes the progress events for reads of existing keys and
+// lookups of absent keys since the last event.
+func (env *ReadEnv) writeEvents(now time.Duration) {
 	d := now - env.lastTime
-	dw := env.read - env.lastRead
-	if dw > 0 && dw > emitInterval {
-		p := Progress{Processed: env.read, Delta: dw, Duration: d}
+	if env.scan || env.cfg.MissRatio < 1 {
+		p := Progress{
+			Processed: env.read,
+			Delta:     env.read - env.lastRead,
+			Duration:  d,
+			Ops:       env.hits.Count(),
+			Latency:   env.hits.Sum(),
+			Hist:      env.hits.snapshot(),
+			Entries:   env.entries,
+		}
+		env.log.Encode(&p)
+	}
+	if !env.scan && env.cfg.MissRatio > 0 {
+		m := Progress{
+			Op:       OpMiss,
+			Duration: d,
+			Ops:      env.misses.Count(),
+			Latency:  env.misses.Sum(),
+			Hist:     env.misses.snapshot(),
+		}
+		env.log.Encode(&m)
+	}
+	env.logReadPercentage()
+	env.lastTime = now
+	env.lastRead = env.read
+	env.hits.Reset()
+	env.misses.Reset()
+	env.totalEntries += env.entries
+	env.entries = 0
+}
+
+// recordWrite accounts for a single background write.
+func (env *ReadEnv) recordWrite(w int, d time.Duration) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if p, ok := env.writes.add(mononow(), uint64(w), d); ok {
+		env.log.Encode(&p)
+	}
+}
+
+// tickWrites writes a background write event if one is due by time.
+func (env *ReadEnv) tickWrites() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.writes == nil {
+		return
+	}
+	if p, ok := env.writes.tick(mononow()); ok {
 		env.log.Encode(&p)
-		env.logReadPercentage()
-		env.lastTime = now
-		env.lastRead = env.read
+	}
+}
+
+func (env *ReadEnv) flushWrites() {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if p, ok := env.writes.flush(mononow()); ok {
+		env.log.Encode(&p)
+	}
+}
+
+// recordRead accounts for the latency of a single read.
+func (env *ReadEnv) recordRead(miss bool, d time.Duration) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if miss {
+		env.misses.Record(d)
+		env.totalMiss.Record(d)
+		// Misses don't call Progress.
+		env.emitIfDue()
+	} else {
+		env.hits.Record(d)
+		env.totalHits.Record(d)
+	}
+}
+
+// missCost returns the mean miss latency relative to the mean hit latency,
+// or zero if there were no hits or misses. See Trailer.MissCost.
+func (env *ReadEnv) missCost() float64 {
+	hit := env.totalHits.Mean()
+	if hit == 0 || env.totalMiss.Count() == 0 {
+		return 0
+	}
+	return float64(env.totalMiss.Mean()) / float64(hit)
+}
+
+// logReadSummary prints hit and miss latencies. The cost of a miss relative to
+// a hit is also recorded in the log trailer.
+func (env *ReadEnv) logReadSummary() {
+	if !env.cfg.LogPercent || env.totalMiss.Count() == 0 {
+		return
+	}
+	hit, miss := env.totalHits.Mean(), env.totalMiss.Mean()
+	fmt.Printf("[Reading] %d hits (mean %v), %d misses (mean %v)  %s\n",
+		env.totalHits.Count(), hit, env.totalMiss.Count(), miss, env.cfg.TestName)
+	if cost := env.missCost(); cost > 0 {
+		fmt.Printf("[Reading] miss cost: %.1f%% of hit latency  %s\n", 100*cost, env.cfg.TestName)
 	}
 }
 
@@ -0,0 +1,245 @@
+package bench
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// runReadEnv runs a read benchmark against a map and returns the number of
+// reads of existing and absent keys, the value bytes written by the background
+// writer, and the log.
+func runReadEnv(t *testing.T, cfg ReadConfig) (reads, misses int, written uint64, l *Log) {
+	dir, err := ioutil.TempDir("", "readbench-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keys, err := CreateKeyFile(filepath.Join(dir, KeyFileName), cfg.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+
+	var (
+		log      bytes.Buffer
+		env      = NewReadEnv(&log, keys, cfg)
+		mu       sync.Mutex
+		db       = make(map[string]int)
+		loaded   bool
+		finished bool
+	)
+	err = env.Run(func(key, value string, lastCall bool) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if finished {
+			t.Error("write after the end of the run")
+		}
+		if loaded {
+			written += uint64(len(value))
+		}
+		loaded = loaded || lastCall
+		db[key] = len(value)
+		return nil
+	}, func(key string) error {
+		mu.Lock()
+		n, ok := db[key]
+		if ok {
+			reads++
+		} else {
+			misses++
+		}
+		mu.Unlock()
+		if !ok {
+			return leveldb.ErrNotFound
+		}
+		env.Progress(n)
+		return nil
+	})
+	mu.Lock()
+	finished = true
+	mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(reads+misses) != keys.Len() {
+		t.Errorf("got %d reads, want %d", reads+misses, keys.Len())
+	}
+	if l, err = decodeLog(&log); err != nil {
+		t.Fatal(err)
+	}
+	return reads, misses, written, l
+}
+
+func TestReadEnvConcurrent(t *testing.T) {
+	cfg := ReadConfig{Size: 4 * 1024 * 1024, KeySize: 32, DataSize: 100, Order: ReadShuffle, MissRatio: 0.1, Readers: 8}
+	reads, misses, _, l := runReadEnv(t, cfg)
+
+	var processed, hits, missOps uint64
+	for _, p := range l.Events {
+		if p.Duration < 0 {
+			t.Errorf("negative duration in event %+v", p)
+		}
+		if p.Op == OpMiss {
+			missOps += p.Ops
+			continue
+		}
+		if p.Processed != processed+p.Delta {
+			t.Errorf("processed %d, want %d", p.Processed, processed+p.Delta)
+		}
+		processed = p.Processed
+		hits += p.Ops
+	}
+	if hits != uint64(reads) {
+		t.Errorf("logged %d hits, want %d", hits, reads)
+	}
+	if missOps != uint64(misses) {
+		t.Errorf("logged %d misses, want %d", missOps, misses)
+	}
+	if l.Trailer.MissCost == 0 {
+		t.Error("trailer has no miss cost")
+	}
+}
+
+func TestReadEnvOnlyMisses(t *testing.T) {
+	cfg := ReadConfig{Size: 1024 * 1024, KeySize: 32, DataSize: 100, MissRatio: 1}
+	_, misses, _, l := runReadEnv(t, cfg)
+
+	var missOps uint64
+	for _, p := range l.Events {
+		if p.Op != OpMiss {
+			t.Fatalf("unexpected event %+v", p)
+		}
+		missOps += p.Ops
+	}
+	if len(l.Events) < 2 {
+		t.Errorf("got %d miss events, want more than one", len(l.Events))
+	}
+	if missOps != uint64(misses) {
+		t.Errorf("logged %d misses, want %d", missOps, misses)
+	}
+}
+
+func TestReadEnvScan(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readbench-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := ReadConfig{Size: 1024 * 1024, KeySize: 32, DataSize: 100, Order: ReadShuffle}
+	keys, err := CreateKeyFile(filepath.Join(dir, KeyFileName), cfg.KeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer keys.Close()
+
+	const scanLength = 50
+	var (
+		log     bytes.Buffer
+		env     = NewReadEnv(&log, keys, cfg)
+		db      = make(map[string]int)
+		sorted  []string
+		scans   uint64
+		entries uint64
+	)
+	err = env.RunScan(func(key, value string, lastCall bool) error {
+		db[key] = len(value)
+		return nil
+	}, func(start string) error {
+		if sorted == nil {
+			for k := range db {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+		}
+		scans++
+		i := sort.SearchStrings(sorted, start)
+		for n := 0; n < scanLength && i < len(sorted); n, i = n+1, i+1 {
+			entries++
+			env.ScanProgress(1, db[sorted[i]])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ops, logged, latencies uint64
+	for _, p := range l.Events {
+		if p.Op != "" {
+			t.Fatalf("unexpected event %+v", p)
+		}
+		ops += p.Ops
+		logged += p.Entries
+		if p.Hist != nil {
+			latencies += p.Hist.Count()
+		}
+	}
+	if ops != scans || latencies != scans {
+		t.Errorf("logged %d scans with %d latencies, want %d", ops, latencies, scans)
+	}
+	if logged != entries {
+		t.Errorf("logged %d entries, want %d", logged, entries)
+	}
+	if l.Trailer.Ops != scans || l.Trailer.Entries != entries {
+		t.Errorf("trailer has %d ops and %d entries, want %d and %d", l.Trailer.Ops, l.Trailer.Entries, scans, entries)
+	}
+	if l.Trailer.Processed < cfg.Size {
+		t.Errorf("scanned %d bytes, want at least %d", l.Trailer.Processed, cfg.Size)
+	}
+}
+
+func TestReadEnvWriting(t *testing.T) {
+	const rate = 1024 * 1024
+	cfg := ReadConfig{Size: 4 * 1024 * 1024, KeySize: 32, DataSize: 100, Order: ReadShuffle, Readers: 4, WriteRate: rate}
+	reads, _, written, l := runReadEnv(t, cfg)
+
+	var (
+		hits, logged uint64
+		readStart    time.Duration
+	)
+	for _, a := range l.Annotations {
+		if a.Text == "read" {
+			readStart = a.Elapsed
+		}
+	}
+	for _, p := range l.Events {
+		switch p.Op {
+		case "":
+			hits += p.Ops
+		case OpWrite:
+			logged += p.Delta
+		default:
+			t.Fatalf("unexpected event %+v", p)
+		}
+	}
+	if hits != uint64(reads) {
+		t.Errorf("logged %d reads, want %d", hits, reads)
+	}
+	if written == 0 {
+		t.Fatal("no background writes")
+	}
+	// All writes are logged, so the writer has flushed its stream before
+	// the run ended.
+	if logged != written {
+		t.Errorf("logged %d written bytes, want %d", logged, written)
+	}
+	// The writer may be ahead of schedule by less than a millisecond, and
+	// by one write.
+	elapsed := l.Trailer.Duration - readStart
+	limit := uint64(float64(rate)*(elapsed+time.Millisecond).Seconds()) + cfg.DataSize
+	if written > limit {
+		t.Errorf("wrote %d bytes in %v, want at most %d at %d bytes/s", written, elapsed, limit, rate)
+	}
+}
@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand"
+)
+
+// Read orders supported by ReadEnv.
+const (
+	ReadInsertion = "insertion" // keys are read in the order they were written
+	ReadShuffle   = "shuffle"   // every key is read once, in random order
+	ReadUniform   = "uniform"   // keys are sampled uniformly at random
+	ReadZipfian   = "zipfian"   // keys are sampled from a hot set scattered across the keyspace
+)
+
+// ReadOrders lists all available read orders.
+var ReadOrders = []string{ReadInsertion, ReadShuffle, ReadUniform, ReadZipfian}
+
+// indexSource produces key file indexes for reading.
+type indexSource interface {
+	next() uint64
+}
+
+// newIndexSource creates an index source for n keys.
+func newIndexSource(order string, r *rand.Rand, n uint64) (indexSource, error) {
+	switch order {
+	case "", ReadInsertion:
+		return new(seqIndex), nil
+	case ReadShuffle:
+		return &shuffledIndex{perm: newPermutation(r, n)}, nil
+	case ReadUniform:
+		return uniformIndex{r, int64(n)}, nil
+	case ReadZipfian:
+		return &zipfIndex{zipf: rand.NewZipf(r, zipfS, 1, n-1), perm: newPermutation(r, n)}, nil
+	default:
+		return nil, fmt.Errorf("unknown read order %q", order)
+	}
+}
+
+type seqIndex struct{ i uint64 }
+
+func (s *seqIndex) next() uint64 {
+	s.i++
+	return s.i - 1
+}
+
+type shuffledIndex struct {
+	i    uint64
+	perm *permutation
+}
+
+func (s *shuffledIndex) next() uint64 {
+	s.i++
+	return s.perm.at(s.i - 1)
+}
+
+type uniformIndex struct {
+	rand *rand.Rand
+	n    int64
+}
+
+func (s uniformIndex) next() uint64 {
+	return uint64(s.rand.Int63n(s.n))
+}
+
+// zipfIndex samples with zipfian distribution. The rank is permuted so the hot
+// keys are not just the ones inserted first.
+type zipfIndex struct {
+	zipf *rand.Zipf
+	perm *permutation
+}
+
+func (s *zipfIndex) next() uint64 {
+	return s.perm.at(s.zipf.Uint64())
+}
+
+// permutation is a pseudo-random permutation of [0, n). It is a Feistel network
+// over the smallest even power of two covering n, with cycle walking to stay
+// within range. Unlike rand.Perm, it doesn't need memory proportional to n.
+type permutation struct {
+	n        uint64
+	halfBits uint
+	keys     [4]uint64
+}
+
+func newPermutation(r *rand.Rand, n uint64) *permutation {
+	b := uint(bits.Len64(n - 1))
+	if b < 2 {
+		b = 2
+	}
+	b += b % 2
+	p := &permutation{n: n, halfBits: b / 2}
+	for i := range p.keys {
+		p.keys[i] = r.Uint64()
+	}
+	return p
+}
+
+// at returns the i'th element of the permutation.
+func (p *permutation) at(i uint64) uint64 {
+	for {
+		i = p.encrypt(i)
+		if i < p.n {
+			return i
+		}
+	}
+}
+
+func (p *permutation) encrypt(x uint64) uint64 {
+	mask := uint64(1)<<p.halfBits - 1
+	l, r := x>>p.halfBits, x&mask
+	for _, k := range p.keys {
+		l, r = r, l^(feistelRound(r, k)&mask)
+	}
+	return l<<p.halfBits | r
+}
+
+func feistelRound(x, k uint64) uint64 {
+	x ^= k
+	x *= 0x9e3779b97f4a7c15
+	x ^= x >> 29
+	x *= 0xbf58476d1ce4e5b9
+	return x ^ x>>32
+}
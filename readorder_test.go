@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPermutation(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []uint64{1, 2, 3, 16, 100, 1000, 4096, 5003} {
+		var (
+			p    = newPermutation(r, n)
+			seen = make([]bool, n)
+		)
+		for i := uint64(0); i < n; i++ {
+			v := p.at(i)
+			if v >= n {
+				t.Fatalf("n=%d: at(%d) = %d out of range", n, i, v)
+			}
+			if seen[v] {
+				t.Fatalf("n=%d: at(%d) = %d is a duplicate", n, i, v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestPermutationShuffles(t *testing.T) {
+	var (
+		n     = uint64(1000)
+		p     = newPermutation(rand.New(rand.NewSource(1)), n)
+		fixed int
+	)
+	for i := uint64(0); i < n; i++ {
+		if p.at(i) == i {
+			fixed++
+		}
+	}
+	if fixed > 10 {
+		t.Errorf("%d of %d elements not moved", fixed, n)
+	}
+}
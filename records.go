@@ -0,0 +1,330 @@
+package bench
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// LogVersion is the version of the log schema written by this package. Logs
+// without header are version 0 and contain progress events only.
+const LogVersion = 1
+
+// Record types. Progress events are written without type to keep logs compact
+// and readable by older tools, but may also have type "progress".
+const (
+	RecordHeader     = "header"
+	RecordProgress   = "progress"
+	RecordStats      = "stats"
+	RecordAnnotation = "annotation"
+	RecordTrailer    = "trailer"
+)
+
+// Storage types of the test database, see WriteConfig.Storage.
+const (
+	StorageFile = "file" // storage.OpenFile in the test database directory
+	StorageMem  = "mem"  // storage.NewMemStorage
+)
+
+const goleveldbModule = "github.com/syndtr/goleveldb"
+
+// Header is the first record of a benchmark log. It describes the test
+// configuration and the system the test ran on.
+type Header struct {
+	Type      string                 `json:"type"`
+	Version   int                    `json:"version"`
+	Test      string                 `json:"test"`
+	Env       string                 `json:"env"` // "write", "read" or "mixed"
+	Time      time.Time              `json:"time"`
+	Config    json.RawMessage        `json:"config"`              // WriteConfig, ReadConfig or MixedConfig
+	Benchmark map[string]interface{} `json:"benchmark,omitempty"` // parameters of the benchmark implementation
+	Options   map[string]interface{} `json:"options,omitempty"`   // non-default goleveldb options
+	System    SystemInfo             `json:"system"`
+}
+
+// SystemInfo describes the machine running a benchmark.
+type SystemInfo struct {
+	GoVersion        string `json:"goversion"`
+	GoleveldbVersion string `json:"goleveldbversion,omitempty"`
+	OS               string `json:"os"`
+	Arch             string `json:"arch"`
+	Hostname         string `json:"hostname,omitempty"`
+	CPU              string `json:"cpu,omitempty"`
+	NumCPU           int    `json:"numcpu"`
+	DBDir            string `json:"dbdir,omitempty"`
+	Filesystem       string `json:"filesystem,omitempty"` // filesystem type of DBDir
+	Device           string `json:"device,omitempty"`     // device containing DBDir
+}
+
+// Trailer is the last record of a benchmark log. It summarizes the run.
+type Trailer struct {
+	Type      string        `json:"type"`
+	Duration  time.Duration `json:"duration"`           // total run time
+	Processed uint64        `json:"processed"`          // total bytes read or written
+	Ops       uint64        `json:"ops,omitempty"`      // total database calls
+	Deleted   uint64        `json:"deleted,omitempty"`  // total entries deleted
+	Entries   uint64        `json:"entries,omitempty"`  // total entries read by iterators
+	MissCost  float64       `json:"misscost,omitempty"` // mean latency of misses relative to hits
+	Error     string        `json:"error,omitempty"`    // error which ended the run
+}
+
+// Stats is a sample of database and system statistics taken during a run.
+type Stats struct {
+	Type    string        `json:"type"`
+	Elapsed time.Duration `json:"elapsed"`         // time since start of the run
+	Final   bool          `json:"final,omitempty"` // sample taken at the end of the run
+	DB      *DBStats      `json:"db,omitempty"`
+	IO      *ProcIO       `json:"io,omitempty"`
+	Disk    *DiskUsage    `json:"disk,omitempty"`
+
+	// Storage holds I/O totals per file type, see CountingStorage.
+	Storage map[string]*IOCount `json:"storage,omitempty"`
+}
+
+// Annotation marks a point in time of a run, e.g. the start of a phase.
+type Annotation struct {
+	Type    string        `json:"type"`
+	Elapsed time.Duration `json:"elapsed"` // time since start of the run
+	Text    string        `json:"text"`
+}
+
+func newHeader(env, test string, cfg interface{}) *Header {
+	h := &Header{Type: RecordHeader, Version: LogVersion, Env: env, Test: test}
+	h.Config, _ = json.Marshal(cfg)
+	return h
+}
+
+// sizes returns the dataset size, key size and value size of the test configuration.
+func (h *Header) sizes() (size, keySize, dataSize uint64) {
+	var cfg struct {
+		Size     uint64 `json:"size"`
+		KeySize  uint64 `json:"keysize"`
+		DataSize uint64 `json:"datasize"`
+	}
+	json.Unmarshal(h.Config, &cfg)
+	return cfg.Size, cfg.KeySize, cfg.DataSize
+}
+
+// DiskProfile returns the name of the simulated disk of the test database, or
+// the empty string if the database is on a real disk.
+func (h *Header) DiskProfile() string {
+	var cfg struct {
+		DiskProfile string `json:"diskprofile"`
+	}
+	json.Unmarshal(h.Config, &cfg)
+	return cfg.DiskProfile
+}
+
+// Storage returns the storage type of the test database, StorageFile or StorageMem.
+func (h *Header) Storage() string {
+	var cfg struct {
+		Storage string `json:"storage"`
+	}
+	json.Unmarshal(h.Config, &cfg)
+	if cfg.Storage == "" {
+		return StorageFile
+	}
+	return cfg.Storage
+}
+
+// describe records the benchmark implementation and database directory.
+func (h *Header) describe(dbdir string, benchmark interface{}) {
+	v := reflect.ValueOf(benchmark)
+	if d, ok := describeValue(v).(map[string]interface{}); ok {
+		d["type"] = v.Type().String()
+		h.Benchmark = d
+	}
+	if o := findOptions(v); o != nil {
+		h.Options, _ = describeValue(reflect.ValueOf(o).Elem()).(map[string]interface{})
+		if h.Options == nil {
+			h.Options = make(map[string]interface{})
+		}
+	}
+	h.System.DBDir = dbdir
+}
+
+// write fills in the system information and writes the header.
+func (h *Header) write(enc *json.Encoder) error {
+	h.Time = time.Now()
+	h.System.fill()
+	return enc.Encode(h)
+}
+
+func newTrailer(duration time.Duration, processed uint64, err error) *Trailer {
+	t := &Trailer{Type: RecordTrailer, Duration: duration, Processed: processed}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	return t
+}
+
+func newAnnotation(elapsed time.Duration, text string) *Annotation {
+	return &Annotation{Type: RecordAnnotation, Elapsed: elapsed, Text: text}
+}
+
+func (s *SystemInfo) fill() {
+	s.GoVersion = runtime.Version()
+	s.OS = runtime.GOOS
+	s.Arch = runtime.GOARCH
+	s.NumCPU = runtime.NumCPU()
+	s.Hostname, _ = os.Hostname()
+	s.CPU = cpuModel()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == goleveldbModule {
+				s.GoleveldbVersion = dep.Version
+				if dep.Replace != nil {
+					s.GoleveldbVersion += " => " + dep.Replace.Path + " " + dep.Replace.Version
+				}
+			}
+		}
+	}
+	if s.DBDir != "" {
+		if abs, err := filepath.Abs(s.DBDir); err == nil {
+			s.DBDir = abs
+		}
+		s.Filesystem, s.Device = mountOf(s.DBDir)
+	}
+}
+
+// cpuModel returns the CPU model name on Linux.
+func cpuModel() string {
+	fd, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer fd.Close()
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "model name" {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// mountOf returns the filesystem type and device of the mount containing path.
+// It only works on Linux.
+func mountOf(path string) (fstype, device string) {
+	fd, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", ""
+	}
+	defer fd.Close()
+	var longest int
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		f := strings.Fields(scanner.Text())
+		if len(f) < 3 {
+			continue
+		}
+		mnt := f[1]
+		if !strings.HasPrefix(path, mnt) || len(mnt) < longest {
+			continue
+		}
+		if mnt != "/" && len(path) > len(mnt) && path[len(mnt)] != '/' {
+			continue
+		}
+		longest, device, fstype = len(mnt), f[0], f[2]
+	}
+	return fstype, device
+}
+
+var optionsType = reflect.TypeOf(opt.Options{})
+
+// findOptions returns the goleveldb options contained in v.
+func findOptions(v reflect.Value) *opt.Options {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return findOptions(v.Elem())
+	case reflect.Struct:
+		if v.Type() == optionsType {
+			o := v.Interface().(opt.Options)
+			return &o
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if o := findOptions(v.Field(i)); o != nil {
+				return o
+			}
+		}
+	}
+	return nil
+}
+
+// describeValue converts v into a value that can be encoded as JSON. Struct
+// fields with zero value are omitted. Interfaces, functions and other values
+// that can't be encoded are described by their name or type.
+func describeValue(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	if v.CanInterface() {
+		switch x := v.Interface().(type) {
+		case interface{ Name() string }:
+			if v.Kind() != reflect.Ptr || !v.IsNil() {
+				return x.Name()
+			}
+		case fmt.Stringer:
+			if v.Kind() != reflect.Ptr && v.Kind() != reflect.Struct {
+				return x.String()
+			}
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		d := describeValue(v.Elem())
+		if m, ok := d.(map[string]interface{}); ok && v.Kind() == reflect.Interface {
+			m["type"] = v.Elem().Type().String()
+		}
+		return d
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.PkgPath != "" || v.Field(i).IsZero() {
+				continue
+			}
+			m[f.Name] = describeValue(v.Field(i))
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		list := make([]interface{}, v.Len())
+		for i := range list {
+			list[i] = describeValue(v.Index(i))
+		}
+		return list
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[fmt.Sprint(iter.Key().Interface())] = describeValue(iter.Value())
+		}
+		return m
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return v.Interface()
+	default:
+		return v.Type().String()
+	}
+}
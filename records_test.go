@@ -0,0 +1,84 @@
+package bench
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+type testBenchmark struct {
+	Options   opt.Options
+	BatchSize int
+}
+
+func TestLogHeader(t *testing.T) {
+	var (
+		log bytes.Buffer
+		cfg = WriteConfig{Size: 2 * defaultEmitSize, KeySize: 32, DataSize: 100, TestName: "test"}
+		env = NewWriteEnv(&log, cfg)
+		b   = testBenchmark{
+			Options:   opt.Options{Filter: filter.NewBloomFilter(10), WriteBuffer: 64 * opt.MiB},
+			BatchSize: 100,
+		}
+	)
+	env.Describe("testdb", b)
+	err := env.Run(func(key, value string, lastCall bool) error {
+		env.Progress(len(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Header == nil || l.Trailer == nil {
+		t.Fatalf("missing header or trailer: %+v", l)
+	}
+	if l.Header.Test != "test" || l.Header.Env != "write" {
+		t.Errorf("wrong test %q, env %q in header", l.Header.Test, l.Header.Env)
+	}
+	if f := l.Header.Options["Filter"]; f != "leveldb.BuiltinBloomFilter" {
+		t.Errorf("wrong filter %v in header options", f)
+	}
+	if n := l.Header.Benchmark["BatchSize"]; n != float64(100) {
+		t.Errorf("wrong BatchSize %v in header", n)
+	}
+	if l.Header.System.GoVersion == "" {
+		t.Error("missing Go version in header")
+	}
+	if l.Trailer.Processed != cfg.Size || l.Trailer.Error != "" {
+		t.Errorf("wrong trailer %+v", l.Trailer)
+	}
+	if len(l.Events) == 0 {
+		t.Error("no progress events")
+	}
+}
+
+func TestLogConfigError(t *testing.T) {
+	var (
+		log bytes.Buffer
+		cfg = WriteConfig{Size: defaultEmitSize, KeySize: 32, DataSize: 100, DeleteRatio: 0.5, TestName: "test"}
+		env = NewWriteEnv(&log, cfg)
+	)
+	// Run has no delete function, so the configuration is invalid.
+	err := env.Run(func(key, value string, lastCall bool) error { return nil })
+	if err == nil {
+		t.Fatal("no error for deletes without delete function")
+	}
+
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Header == nil || l.Trailer == nil {
+		t.Fatalf("missing header or trailer: %+v", l)
+	}
+	if l.Trailer.Error == "" {
+		t.Errorf("no error in trailer %+v", l.Trailer)
+	}
+}
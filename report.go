@@ -1,11 +1,11 @@
 package bench
 
 import (
-	"encoding/json"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -13,9 +13,15 @@ import (
 )
 
 type Progress struct {
-	Processed uint64        `json:"processed"` // total bytes read or written so far
-	Delta     uint64        `json:"delta"`     // bytes written since last event
-	Duration  time.Duration `json:"duration"`  // time in ns since last event
+	Op        string        `json:"op,omitempty"`      // operation type, for logs with several kinds of operations
+	Processed uint64        `json:"processed"`         // total bytes read or written so far
+	Delta     uint64        `json:"delta"`             // bytes written since last event
+	Duration  time.Duration `json:"duration"`          // time in ns since last event
+	Ops       uint64        `json:"ops,omitempty"`     // operations since last event
+	Latency   time.Duration `json:"latency,omitempty"` // total time spent in operations since last event
+	Hist      *Histogram    `json:"hist,omitempty"`    // latency of database calls since last event
+	Deleted   uint64        `json:"deleted,omitempty"` // entries deleted since last event
+	Entries   uint64        `json:"entries,omitempty"` // entries read by iterators since last event
 }
 
 // BPS returns the 'write/read speed' in bytes/s.
@@ -23,51 +29,265 @@ func (ev Progress) BPS() float64 {
 	return (float64(ev.Delta) / float64(ev.Duration)) * float64(time.Second)
 }
 
+// OPS returns the number of operations per second.
+func (ev Progress) OPS() float64 {
+	return (float64(ev.Ops) / float64(ev.Duration)) * float64(time.Second)
+}
+
 func mononow() time.Duration {
 	return time.Duration(monotime.Now())
 }
 
+// Log is the content of a benchmark log file.
+type Log struct {
+	Header      *Header // nil for logs written without header
+	Events      []Progress
+	Stats       []Stats
+	Annotations []Annotation
+	Trailer     *Trailer // nil if the log has no trailer
+}
+
+// ReadLog reads all records of a benchmark log file into memory.
+func ReadLog(file string) (*Log, error) {
+	r, err := OpenLog(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLog(r)
+}
+
 // ReadProgress reads JSON progress events in a file.
 func ReadProgress(file string) ([]Progress, error) {
-	fd, err := os.Open(file)
+	l, err := ReadLog(file)
+	if l == nil {
+		return nil, err
+	}
+	return l.Events, err
+}
+
+func decodeLog(in io.Reader) (*Log, error) {
+	r, err := NewLogReader(in)
 	if err != nil {
 		return nil, err
 	}
-	var pp []Progress
-	dec := json.NewDecoder(fd)
-	for {
-		var p Progress
-		if err := dec.Decode(&p); err == io.EOF {
-			break
-		} else if err != nil {
-			return pp, err
+	return readLog(r)
+}
+
+func readLog(r *LogReader) (*Log, error) {
+	l := new(Log)
+	for r.Next() {
+		switch rec := r.Record().(type) {
+		case *Header:
+			l.Header = rec
+		case *Progress:
+			l.Events = append(l.Events, *rec)
+		case *Stats:
+			l.Stats = append(l.Stats, *rec)
+		case *Annotation:
+			l.Annotations = append(l.Annotations, *rec)
+		case *Trailer:
+			l.Trailer = rec
 		}
-		pp = append(pp, p)
 	}
-	return pp, nil
+	return l, r.Err()
 }
 
 type Report struct {
-	Name   string
-	Events []Progress
+	Name        string
+	Header      *Header  // nil for logs without header
+	Trailer     *Trailer // nil for logs without trailer
+	Events      []Progress
+	Stats       []Stats
+	Annotations []Annotation
+	hist        *Histogram // merged latency histograms of events
+}
+
+// Test returns the test name in the log header followed by the operation type,
+// e.g. "ycsb-a/read". Unlike the report name, it doesn't depend on the log file
+// name, which may contain a timestamp. For logs without header, it is the
+// report name.
+func (r Report) Test() string {
+	if r.Header == nil || r.Header.Test == "" {
+		return r.Name
+	}
+	test := r.Header.Test
+	if i := strings.IndexByte(r.Name, '/'); i >= 0 {
+		test += r.Name[i:]
+	}
+	return test
+}
+
+// Histogram returns the combined latency histogram of all events, or nil if
+// the report has no latency data.
+func (r Report) Histogram() *Histogram {
+	var h Histogram
+	if r.hist != nil {
+		h.Merge(r.hist)
+	}
+	for _, ev := range r.Events {
+		if ev.Hist != nil {
+			h.Merge(ev.Hist)
+		}
+	}
+	return h.snapshot()
 }
 
-// MustReadReports reads all given progress event files.
+// MustReadReports reads all given progress event files. Files containing
+// several kinds of operations are split into one report per operation type,
+// named "<file>/<op>".
+//
+// To save memory, latency histograms of events are merged while reading. The
+// events of the returned reports have no histogram, use Report.Histogram instead.
 func MustReadReports(files []string) []Report {
 	var reports []Report
 	for _, file := range files {
-		p, err := ReadProgress(file)
+		r, err := readReports(file)
 		if err != nil {
 			log.Fatalf("%s: %v", file, err)
 		}
-		reports = append(reports, Report{
-			Events: p,
-			Name:   strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)),
-		})
+		reports = append(reports, r...)
 	}
 	return reports
 }
 
+// readReports streams the records of a log file into reports, one report per
+// operation type. The reports are ordered by first appearance of the operation.
+func readReports(file string) ([]Report, error) {
+	r, err := OpenLog(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var (
+		name        = logName(file)
+		reports     []Report
+		index       = make(map[string]int)
+		header      *Header
+		trailer     *Trailer
+		stats       []Stats
+		annotations []Annotation
+	)
+	for r.Next() {
+		switch rec := r.Record().(type) {
+		case *Header:
+			header = rec
+		case *Trailer:
+			trailer = rec
+		case *Stats:
+			stats = append(stats, *rec)
+		case *Annotation:
+			annotations = append(annotations, *rec)
+		case *Progress:
+			i, ok := index[rec.Op]
+			if !ok {
+				i = len(reports)
+				index[rec.Op] = i
+				rname := name
+				if rec.Op != "" {
+					rname += "/" + rec.Op
+				}
+				reports = append(reports, Report{Name: rname})
+			}
+			if rec.Hist != nil {
+				if reports[i].hist == nil {
+					reports[i].hist = new(Histogram)
+				}
+				reports[i].hist.Merge(rec.Hist)
+				rec.Hist = nil
+			}
+			reports[i].Events = append(reports[i].Events, *rec)
+		}
+	}
+	if r.Err() != nil {
+		return nil, r.Err()
+	}
+	if len(reports) == 0 {
+		reports = append(reports, Report{Name: name})
+	}
+	for i := range reports {
+		reports[i].Header, reports[i].Trailer = header, trailer
+		reports[i].Stats, reports[i].Annotations = stats, annotations
+	}
+	return reports, nil
+}
+
+// logName returns the name of a log file without directory and extensions.
+func logName(file string) string {
+	name := filepath.Base(file)
+	name = strings.TrimSuffix(name, ".gz")
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// isLogFile reports whether file has the extension of a log file.
+func isLogFile(file string) bool {
+	return strings.HasSuffix(file, ".json") || strings.HasSuffix(file, ".json.gz")
+}
+
+// MustReadRuns reads the reports in path, keyed by report name. If path is a
+// directory, all .json and .json.gz files below it are read and reports with
+// equal name are treated as repeated runs of the same test, e.g.
+// old/1/batch-100kb.json and old/2/batch-100kb.json.
+func MustReadRuns(path string) map[string][]Report {
+	var files []string
+	err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if file == path && !info.IsDir() || !info.IsDir() && isLogFile(file) {
+			files = append(files, file)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	runs := make(map[string][]Report)
+	for _, r := range MustReadReports(files) {
+		runs[r.Name] = append(runs[r.Name], r)
+	}
+	return runs
+}
+
+// StoragePair holds the runs of a test on file and memory storage.
+type StoragePair struct {
+	Test string
+	File []Report
+	Mem  []Report
+}
+
+// PairByStorage groups the reports of each test by the storage type of the test
+// database. Reports are matched by Test, so logs with timestamps in their file
+// name pair up. Runs on a simulated disk are kept apart from other runs of the
+// test, the name of the disk profile is appended to their test name. Reports
+// without header count as file storage. The result is sorted by test name.
+func PairByStorage(reports []Report) []StoragePair {
+	var (
+		pairs []StoragePair
+		index = make(map[string]int)
+	)
+	for _, r := range reports {
+		test := r.Test()
+		if r.Header != nil && r.Header.DiskProfile() != "" {
+			test += " (" + r.Header.DiskProfile() + ")"
+		}
+		i, ok := index[test]
+		if !ok {
+			i = len(pairs)
+			index[test] = i
+			pairs = append(pairs, StoragePair{Test: test})
+		}
+		if r.Header != nil && r.Header.Storage() == StorageMem {
+			pairs[i].Mem = append(pairs[i].Mem, r)
+		} else {
+			pairs[i].File = append(pairs[i].File, r)
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Test < pairs[j].Test })
+	return pairs
+}
+
 // copyBytes returns an exact copy of the provided bytes.
 func copyBytes(b []byte) (copiedBytes []byte) {
 	if b == nil {
@@ -0,0 +1,42 @@
+package bench
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPairByStorage(t *testing.T) {
+	header := func(test, storage string) *Header {
+		return newHeader("write", test, WriteConfig{Storage: storage})
+	}
+	reports := []Report{
+		{Name: "b.2020-01-01", Header: header("b", StorageMem)},
+		{Name: "a", Header: header("a", StorageFile)},
+		{Name: "b.2020-01-02", Header: header("b", "")},
+		{Name: "a/read", Header: &Header{Test: "a", Config: json.RawMessage(`{"storage":"mem"}`)}},
+		{Name: "legacy"},
+		{Name: "b.2020-01-03", Header: newHeader("write", "b", WriteConfig{Storage: StorageMem, DiskProfile: "hdd"})},
+	}
+	pairs := PairByStorage(reports)
+
+	want := []struct {
+		test      string
+		file, mem int
+	}{
+		{"a", 1, 0},
+		{"a/read", 0, 1},
+		{"b", 1, 1},
+		{"b (hdd)", 0, 1},
+		{"legacy", 1, 0},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("got %d pairs, want %d", len(pairs), len(want))
+	}
+	for i, w := range want {
+		p := pairs[i]
+		if p.Test != w.test || len(p.File) != w.file || len(p.Mem) != w.mem {
+			t.Errorf("pair %d: got %s with %d file, %d mem runs, want %s with %d, %d",
+				i, p.Test, len(p.File), len(p.Mem), w.test, w.file, w.mem)
+		}
+	}
+}
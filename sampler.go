@@ -0,0 +1,254 @@
+package bench
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Sampler adds statistics to a stats record. Samplers are called periodically
+// while a benchmark runs and once more at the end, with Stats.Final set.
+type Sampler interface {
+	Sample(s *Stats) error
+}
+
+// DBStats are goleveldb internal statistics.
+type DBStats struct {
+	WriteDelayCount    int32         `json:"writedelaycount"`
+	WriteDelayDuration time.Duration `json:"writedelayduration"`
+	WritePaused        bool          `json:"writepaused,omitempty"`
+	AliveSnapshots     int32         `json:"alivesnapshots,omitempty"`
+	AliveIterators     int32         `json:"aliveiterators,omitempty"`
+	IOWrite            uint64        `json:"iowrite"` // bytes written to storage
+	IORead             uint64        `json:"ioread"`  // bytes read from storage
+	BlockCacheSize     int           `json:"blockcachesize"`
+	CachedBlocks       int           `json:"cachedblocks"`
+	OpenedTables       int           `json:"openedtables"`
+	BlockPool          string        `json:"blockpool,omitempty"`
+
+	// Per-level statistics. LevelRead, LevelWrite and LevelDurations are the
+	// bytes read, bytes written and time spent by compactions into the level.
+	LevelSizes     []int64         `json:"levelsizes"`
+	LevelTables    []int           `json:"leveltables"`
+	LevelRead      []int64         `json:"levelread"`
+	LevelWrite     []int64         `json:"levelwrite"`
+	LevelDurations []time.Duration `json:"leveldurations"`
+
+	// Properties holds the text of the "leveldb.stats" and "leveldb.iostats"
+	// properties. It is only set in the final sample.
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type dbSampler struct {
+	db *leveldb.DB
+}
+
+// NewDBSampler creates a sampler for the internal statistics of db.
+func NewDBSampler(db *leveldb.DB) Sampler {
+	return dbSampler{db}
+}
+
+func (s dbSampler) Sample(st *Stats) error {
+	var ds leveldb.DBStats
+	if err := s.db.Stats(&ds); err != nil {
+		return err
+	}
+	st.DB = &DBStats{
+		WriteDelayCount:    ds.WriteDelayCount,
+		WriteDelayDuration: ds.WriteDelayDuration,
+		WritePaused:        ds.WritePaused,
+		AliveSnapshots:     ds.AliveSnapshots,
+		AliveIterators:     ds.AliveIterators,
+		IOWrite:            ds.IOWrite,
+		IORead:             ds.IORead,
+		BlockCacheSize:     ds.BlockCacheSize,
+		OpenedTables:       ds.OpenedTablesCount,
+		LevelSizes:         ds.LevelSizes,
+		LevelTables:        ds.LevelTablesCounts,
+		LevelRead:          ds.LevelRead,
+		LevelWrite:         ds.LevelWrite,
+		LevelDurations:     ds.LevelDurations,
+	}
+	if v, err := s.db.GetProperty("leveldb.cachedblock"); err == nil {
+		st.DB.CachedBlocks, _ = strconv.Atoi(v)
+	}
+	if v, err := s.db.GetProperty("leveldb.blockpool"); err == nil {
+		st.DB.BlockPool = v
+	}
+	if st.Final {
+		st.DB.Properties = make(map[string]string)
+		for _, name := range []string{"leveldb.stats", "leveldb.iostats"} {
+			if v, err := s.db.GetProperty(name); err == nil {
+				st.DB.Properties[name] = v
+			}
+		}
+	}
+	return nil
+}
+
+// ProcIO contains the I/O counters of the benchmark process from /proc/self/io.
+// ReadBytes and WriteBytes count storage I/O, RChar and WChar count all bytes
+// passed to read and write system calls.
+type ProcIO struct {
+	RChar               uint64 `json:"rchar"`
+	WChar               uint64 `json:"wchar"`
+	SyscR               uint64 `json:"syscr"`
+	SyscW               uint64 `json:"syscw"`
+	ReadBytes           uint64 `json:"readbytes"`
+	WriteBytes          uint64 `json:"writebytes"`
+	CancelledWriteBytes uint64 `json:"cancelledwritebytes"`
+}
+
+type procIOSampler struct{}
+
+// NewProcIOSampler creates a sampler for the I/O counters of the process. It
+// only works on Linux.
+func NewProcIOSampler() Sampler {
+	return procIOSampler{}
+}
+
+func (procIOSampler) Sample(st *Stats) error {
+	fd, err := os.Open("/proc/self/io")
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	var (
+		io     ProcIO
+		fields = map[string]*uint64{
+			"rchar":                 &io.RChar,
+			"wchar":                 &io.WChar,
+			"syscr":                 &io.SyscR,
+			"syscw":                 &io.SyscW,
+			"read_bytes":            &io.ReadBytes,
+			"write_bytes":           &io.WriteBytes,
+			"cancelled_write_bytes": &io.CancelledWriteBytes,
+		}
+	)
+	scanner := bufio.NewScanner(fd)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 || fields[kv[0]] == nil {
+			continue
+		}
+		if *fields[kv[0]], err = strconv.ParseUint(strings.TrimSpace(kv[1]), 10, 64); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	st.IO = &io
+	return nil
+}
+
+// DiskUsage is the size of the database directory.
+type DiskUsage struct {
+	Bytes uint64 `json:"bytes"`
+	Files int    `json:"files"`
+}
+
+type dirSizeSampler struct {
+	dir string
+}
+
+// NewDirSizeSampler creates a sampler for the total size of files in dir. The
+// key file of a read benchmark, KeyFileName, is not counted.
+func NewDirSizeSampler(dir string) Sampler {
+	return dirSizeSampler{dir}
+}
+
+func (s dirSizeSampler) Sample(st *Stats) error {
+	var du DiskUsage
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may be deleted by compaction while walking.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() && path != filepath.Join(s.dir, KeyFileName) {
+			du.Bytes += uint64(info.Size())
+			du.Files++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	st.Disk = &du
+	return nil
+}
+
+// sampling runs samplers periodically.
+type sampling struct {
+	mu       sync.Mutex
+	samplers []Sampler
+	interval time.Duration
+}
+
+func (sm *sampling) add(s Sampler) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.samplers = append(sm.samplers, s)
+}
+
+// sample creates a stats record. Errors of individual samplers are ignored
+// because the database may be closed when the final sample is taken.
+func (sm *sampling) sample(elapsed time.Duration, final bool) *Stats {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	if len(sm.samplers) == 0 {
+		return nil
+	}
+	st := &Stats{Type: RecordStats, Elapsed: elapsed, Final: final}
+	for _, s := range sm.samplers {
+		s.Sample(st)
+	}
+	return st
+}
+
+// start writes an initial sample and runs the samplers every interval, passing
+// the records to write. The returned function stops sampling and writes the
+// final sample.
+func (sm *sampling) start(startTime time.Duration, write func(*Stats)) (stop func()) {
+	var (
+		quit = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	if st := sm.sample(0, false); st != nil {
+		write(st)
+	}
+	if sm.interval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(sm.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if st := sm.sample(mononow()-startTime, false); st != nil {
+						write(st)
+					}
+				case <-quit:
+					return
+				}
+			}
+		}()
+	}
+	return func() {
+		close(quit)
+		wg.Wait()
+		if st := sm.sample(mononow()-startTime, true); st != nil {
+			write(st)
+		}
+	}
+}
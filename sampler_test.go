@@ -0,0 +1,78 @@
+package bench
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestDBSampler(t *testing.T) {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var (
+		log bytes.Buffer
+		cfg = WriteConfig{Size: 1024 * 1024, KeySize: 32, DataSize: 100, StatsInterval: time.Millisecond}
+		env = NewWriteEnv(&log, cfg)
+	)
+	env.AddSampler(NewDBSampler(db))
+	err = env.Run(func(key, value string, lastCall bool) error {
+		if err := db.Put([]byte(key), []byte(value), nil); err != nil {
+			return err
+		}
+		env.Progress(len(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := decodeLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Stats) == 0 {
+		t.Fatal("no stats records")
+	}
+	final := l.Stats[len(l.Stats)-1]
+	if !final.Final || final.DB == nil {
+		t.Fatalf("last stats record is not final: %+v", final)
+	}
+	if final.DB.Properties["leveldb.stats"] == "" {
+		t.Error("final stats record has no leveldb.stats property")
+	}
+	if final.DB.IOWrite < cfg.Size {
+		t.Errorf("final stats record has IOWrite %d, want at least %d", final.DB.IOWrite, cfg.Size)
+	}
+}
+
+func TestDirSizeSamplerKeyFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sampler-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "000001.ldb"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, KeyFileName), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var st Stats
+	if err := NewDirSizeSampler(dir).Sample(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Disk.Bytes != 100 || st.Disk.Files != 1 {
+		t.Errorf("got %d bytes in %d files, want 100 bytes in 1 file", st.Disk.Bytes, st.Disk.Files)
+	}
+}
@@ -0,0 +1,158 @@
+package bench
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// DiskProfile describes the performance of a simulated disk.
+type DiskProfile struct {
+	ReadBPS      uint64        // read throughput in bytes/s, zero is unlimited
+	WriteBPS     uint64        // write throughput in bytes/s, zero is unlimited
+	ReadLatency  time.Duration // cost of each read
+	WriteLatency time.Duration // cost of each write
+	SyncLatency  time.Duration // cost of each sync
+}
+
+// DiskProfiles contains the disk profiles for SlowStorage. The values are
+// typical for each kind of device.
+var DiskProfiles = map[string]DiskProfile{
+	"hdd": {
+		ReadBPS:      150 * 1024 * 1024,
+		WriteBPS:     150 * 1024 * 1024,
+		ReadLatency:  8 * time.Millisecond,
+		WriteLatency: 100 * time.Microsecond,
+		SyncLatency:  10 * time.Millisecond,
+	},
+	"sata-ssd": {
+		ReadBPS:      500 * 1024 * 1024,
+		WriteBPS:     450 * 1024 * 1024,
+		ReadLatency:  100 * time.Microsecond,
+		WriteLatency: 50 * time.Microsecond,
+		SyncLatency:  2 * time.Millisecond,
+	},
+	"nvme": {
+		ReadBPS:      3000 * 1024 * 1024,
+		WriteBPS:     2000 * 1024 * 1024,
+		ReadLatency:  20 * time.Microsecond,
+		WriteLatency: 10 * time.Microsecond,
+		SyncLatency:  200 * time.Microsecond,
+	},
+	"network-disk": {
+		ReadBPS:      200 * 1024 * 1024,
+		WriteBPS:     200 * 1024 * 1024,
+		ReadLatency:  1 * time.Millisecond,
+		WriteLatency: 1 * time.Millisecond,
+		SyncLatency:  5 * time.Millisecond,
+	},
+}
+
+// DiskProfileNames returns the names of all disk profiles.
+func DiskProfileNames() []string {
+	var names []string
+	for name := range DiskProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SlowStorage is a storage.Storage which delays reads, writes and syncs
+// according to a disk profile. All files share a single simulated device,
+// which processes one operation at a time.
+type SlowStorage struct {
+	storage.Storage
+	profile DiskProfile
+
+	mu        sync.Mutex
+	busyUntil time.Time
+}
+
+// NewSlowStorage wraps s.
+func NewSlowStorage(s storage.Storage, profile DiskProfile) *SlowStorage {
+	return &SlowStorage{Storage: s, profile: profile}
+}
+
+// Open implements storage.Storage.
+func (s *SlowStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &slowReader{r, s}, nil
+}
+
+// Create implements storage.Storage.
+func (s *SlowStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &slowWriter{w, s}, nil
+}
+
+const (
+	// Delays shorter than minSleep are accumulated instead of sleeping, because
+	// sleeps overshoot by up to a millisecond.
+	minSleep = time.Millisecond
+	// The device is considered idle after idleGap without operations. Shorter
+	// gaps are credited against the next operations to compensate for
+	// overshooting sleeps.
+	idleGap = 10 * time.Millisecond
+)
+
+// wait occupies the device for an operation which costs latency plus the time to
+// transfer n bytes at bps, and sleeps until the operation is done.
+func (s *SlowStorage) wait(latency time.Duration, n int, bps uint64) {
+	cost := latency
+	if bps > 0 {
+		cost += time.Duration(float64(n) / float64(bps) * float64(time.Second))
+	}
+	s.mu.Lock()
+	if now := time.Now(); now.Sub(s.busyUntil) > idleGap {
+		s.busyUntil = now
+	}
+	s.busyUntil = s.busyUntil.Add(cost)
+	done := s.busyUntil
+	s.mu.Unlock()
+	if d := time.Until(done); d >= minSleep {
+		time.Sleep(d)
+	}
+}
+
+type slowReader struct {
+	storage.Reader
+	s *SlowStorage
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.s.wait(r.s.profile.ReadLatency, n, r.s.profile.ReadBPS)
+	return n, err
+}
+
+func (r *slowReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.Reader.ReadAt(p, off)
+	r.s.wait(r.s.profile.ReadLatency, n, r.s.profile.ReadBPS)
+	return n, err
+}
+
+type slowWriter struct {
+	storage.Writer
+	s *SlowStorage
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.s.wait(w.s.profile.WriteLatency, n, w.s.profile.WriteBPS)
+	return n, err
+}
+
+func (w *slowWriter) Sync() error {
+	err := w.Writer.Sync()
+	w.s.wait(w.s.profile.SyncLatency, 0, 0)
+	return err
+}
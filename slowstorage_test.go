@@ -0,0 +1,103 @@
+package bench
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func TestSlowStorage(t *testing.T) {
+	profile := DiskProfile{WriteBPS: 1024 * 1024, WriteLatency: time.Millisecond, SyncLatency: 5 * time.Millisecond}
+	stor := NewSlowStorage(storage.NewMemStorage(), profile)
+	w, err := stor.Create(storage.FileDesc{Type: storage.TypeTable, Num: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := w.Write(make([]byte, 10*1024)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	// 10 writes of 10KB at 1MB/s take ~98ms, plus 10ms write latency and 5ms for the sync.
+	want := 10*time.Millisecond + 5*time.Millisecond + time.Duration(float64(100*1024)/float64(1024*1024)*float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("writes took %v, want at least %v", elapsed, want)
+	}
+}
+
+func TestSlowStorageReads(t *testing.T) {
+	var (
+		mem   = storage.NewMemStorage()
+		fd    = storage.FileDesc{Type: storage.TypeTable, Num: 1}
+		data  = make([]byte, 10*1024)
+		stor  = NewSlowStorage(mem, DiskProfile{ReadBPS: 1024 * 1024, ReadLatency: 2 * time.Millisecond})
+		start time.Time
+	)
+	w, err := mem.Create(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(data)
+	w.Close()
+
+	r, err := stor.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := r.ReadAt(data, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 10 reads of 10KB at 1MB/s take ~98ms, plus 20ms read latency.
+	want := 20*time.Millisecond + time.Duration(float64(100*1024)/float64(1024*1024)*float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("reads took %v, want at least %v", elapsed, want)
+	}
+}
+
+// Operations on different files share the device, so concurrent writes take as
+// long as if they were done one after another.
+func TestSlowStorageSerialized(t *testing.T) {
+	const files = 4
+	var (
+		stor  = NewSlowStorage(storage.NewMemStorage(), DiskProfile{WriteLatency: 5 * time.Millisecond})
+		errc  = make(chan error, files)
+		start = time.Now()
+	)
+	for i := 0; i < files; i++ {
+		go func(num int64) {
+			w, err := stor.Create(storage.FileDesc{Type: storage.TypeTable, Num: num})
+			if err != nil {
+				errc <- err
+				return
+			}
+			defer w.Close()
+			for j := 0; j < 5; j++ {
+				if _, err := w.Write([]byte{1}); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}(int64(i))
+	}
+	for i := 0; i < files; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 20 writes of 5ms each.
+	if elapsed, want := time.Since(start), 100*time.Millisecond; elapsed < want {
+		t.Errorf("concurrent writes took %v, want at least %v", elapsed, want)
+	}
+}
@@ -0,0 +1,217 @@
+package bench
+
+import (
+	"sort"
+	"time"
+
+	"gonum.org/v1/gonum/stat"
+)
+
+// LatencyQuantiles are the latency quantiles reported by Summarize.
+var LatencyQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// ThroughputQuantiles are the throughput quantiles reported by Summarize.
+var ThroughputQuantiles = []float64{0.01, 0.1, 0.5, 0.9, 0.99}
+
+// Summary contains statistics of a report.
+type Summary struct {
+	Name      string        `json:"name"`
+	Events    int           `json:"events"`
+	TotalTime time.Duration `json:"totaltime"`
+	TotalSize uint64        `json:"totalsize"`
+	TotalOps  uint64        `json:"totalops,omitempty"`
+
+	// TotalEntries is the number of entries read by iterators, if the log
+	// contains it. EntriesPS is the rate at which they were read.
+	TotalEntries uint64  `json:"totalentries,omitempty"`
+	EntriesPS    float64 `json:"entriesps,omitempty"`
+
+	// TotalLatency is the time spent in database calls, if the log contains it.
+	TotalLatency time.Duration `json:"totallatency,omitempty"`
+
+	// Throughput in bytes/s, weighted by event duration. BPSQuantiles holds the
+	// values for ThroughputQuantiles, i.e. the throughput the run fell below
+	// during the given fraction of time.
+	MeanBPS      float64   `json:"meanbps"`
+	StdBPS       float64   `json:"stdbps"`
+	BPSQuantiles []float64 `json:"bpsquantiles"`
+	OPS          float64   `json:"ops,omitempty"`
+
+	// Latency holds the values for LatencyQuantiles. If the report has
+	// latency data, these are latencies of database calls. Otherwise they
+	// are computed from event durations, and EventLatency is true.
+	Latency      []time.Duration `json:"latency"`
+	MaxLatency   time.Duration   `json:"maxlatency"`
+	EventLatency bool            `json:"eventlatency,omitempty"`
+
+	// LongestStall is the longest run of consecutive events which reported no
+	// progress.
+	LongestStall time.Duration `json:"longeststall"`
+
+	// Amplification, computed from the stats records of write and read
+	// benchmarks. WriteAmp and ReadAmp are the bytes written to and read from
+	// storage per logical byte (key and value) written or read. SpaceAmp is
+	// the size of the database directory relative to the logical size of the
+	// data. For workloads with updates and deletes, the logical size is the
+	// total size written, so SpaceAmp is underestimated.
+	WriteAmp float64 `json:"writeamp,omitempty"`
+	ReadAmp  float64 `json:"readamp,omitempty"`
+	SpaceAmp float64 `json:"spaceamp,omitempty"`
+}
+
+// Summarize computes statistics of a report.
+func Summarize(r Report) Summary {
+	s := Summary{Name: r.Name, Events: len(r.Events)}
+	if len(r.Events) == 0 {
+		return s
+	}
+
+	var (
+		bps    = make([]float64, len(r.Events))
+		weight = make([]float64, len(r.Events))
+		stall  time.Duration
+	)
+	for i, ev := range r.Events {
+		bps[i] = ev.BPS()
+		weight[i] = float64(ev.Duration)
+		s.TotalTime += ev.Duration
+		s.TotalSize += ev.Delta
+		s.TotalOps += ev.Ops
+		s.TotalLatency += ev.Latency
+		s.TotalEntries += ev.Entries
+
+		if ev.Delta == 0 && ev.Ops == 0 {
+			stall += ev.Duration
+			continue
+		}
+		if stall > s.LongestStall {
+			s.LongestStall = stall
+		}
+		stall = 0
+	}
+	if stall > s.LongestStall {
+		s.LongestStall = stall
+	}
+
+	s.MeanBPS, s.StdBPS = stat.MeanStdDev(bps, weight)
+	s.BPSQuantiles = weightedQuantiles(bps, weight, ThroughputQuantiles)
+	if s.TotalOps > 0 && s.TotalTime > 0 {
+		s.OPS = float64(s.TotalOps) / s.TotalTime.Seconds()
+	}
+	if s.TotalEntries > 0 && s.TotalTime > 0 {
+		s.EntriesPS = float64(s.TotalEntries) / s.TotalTime.Seconds()
+	}
+
+	h := r.Histogram()
+	if h == nil {
+		h = new(Histogram)
+		for _, ev := range r.Events {
+			h.Record(ev.Duration)
+		}
+		s.EventLatency = true
+	}
+	for _, q := range LatencyQuantiles {
+		s.Latency = append(s.Latency, h.Quantile(q))
+	}
+	s.MaxLatency = h.Max()
+	s.amplification(r)
+	return s
+}
+
+// amplification computes the amplification factors of the main operation of
+// a write or read benchmark.
+func (s *Summary) amplification(r Report) {
+	if r.Header == nil || len(r.Stats) < 2 || r.Events[0].Op != "" {
+		return
+	}
+	size, keySize, dataSize := r.Header.sizes()
+	if dataSize == 0 {
+		return
+	}
+	logical := float64(s.TotalSize)
+	if r.Trailer != nil {
+		logical = float64(r.Trailer.Processed)
+	}
+	logical = logical * float64(keySize+dataSize) / float64(dataSize)
+	if logical == 0 {
+		return
+	}
+
+	first := r.Stats[0]
+	if r.Header.Env == "read" {
+		// Only the read phase counts, not the construction of the dataset.
+		var ok bool
+		if first, ok = r.statsAt("read"); !ok {
+			return
+		}
+	}
+	var (
+		last        = r.Stats[len(r.Stats)-1]
+		read, write float64
+		disk        uint64
+	)
+	switch {
+	case first.IO != nil && last.IO != nil:
+		read = float64(last.IO.ReadBytes) - float64(first.IO.ReadBytes)
+		// Writes to files which were deleted before reaching storage
+		// are cancelled. The cancelled bytes can exceed the bytes
+		// written during the run.
+		write = float64(last.IO.WriteBytes) - float64(last.IO.CancelledWriteBytes) -
+			(float64(first.IO.WriteBytes) - float64(first.IO.CancelledWriteBytes))
+		if write < 0 {
+			write = 0
+		}
+	case first.DB != nil && last.DB != nil:
+		read = float64(last.DB.IORead) - float64(first.DB.IORead)
+		write = float64(last.DB.IOWrite) - float64(first.DB.IOWrite)
+	}
+	if last.Disk != nil {
+		disk = last.Disk.Bytes
+	}
+	switch r.Header.Env {
+	case "write":
+		s.WriteAmp = write / logical
+		s.SpaceAmp = float64(disk) / logical
+	case "read":
+		s.ReadAmp = read / logical
+		dataset := float64(size) * float64(keySize+dataSize) / float64(dataSize)
+		s.SpaceAmp = float64(disk) / dataset
+	}
+}
+
+// statsAt returns the first stats record taken at or after the annotation with
+// the given text. It fails if there is no such annotation, or no later record
+// to compare it with.
+func (r Report) statsAt(annotation string) (Stats, bool) {
+	for _, a := range r.Annotations {
+		if a.Text != annotation {
+			continue
+		}
+		for i, st := range r.Stats[:len(r.Stats)-1] {
+			if st.Elapsed >= a.Elapsed {
+				return r.Stats[i], true
+			}
+		}
+		break
+	}
+	return Stats{}, false
+}
+
+// weightedQuantiles computes quantiles of x. The input slices are not modified.
+func weightedQuantiles(x, weight []float64, qs []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return x[idx[i]] < x[idx[j]] })
+	sx := make([]float64, len(x))
+	sw := make([]float64, len(x))
+	for i, j := range idx {
+		sx[i], sw[i] = x[j], weight[j]
+	}
+	result := make([]float64, len(qs))
+	for i, q := range qs {
+		result[i] = stat.Quantile(q, stat.Empirical, sx, sw)
+	}
+	return result
+}
@@ -0,0 +1,96 @@
+package bench
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	r := Report{Name: "test"}
+	for i := 0; i < 10; i++ {
+		ev := Progress{Delta: 1000, Duration: 100 * time.Millisecond, Ops: 10}
+		if i == 4 || i == 5 {
+			// Two events without progress form a stall.
+			ev.Delta, ev.Ops = 0, 0
+		}
+		r.Events = append(r.Events, ev)
+	}
+	s := Summarize(r)
+	if s.TotalTime != time.Second {
+		t.Errorf("TotalTime = %v, want 1s", s.TotalTime)
+	}
+	if s.TotalOps != 80 || s.OPS != 80 {
+		t.Errorf("TotalOps = %d, OPS = %v, want 80", s.TotalOps, s.OPS)
+	}
+	if s.LongestStall != 200*time.Millisecond {
+		t.Errorf("LongestStall = %v, want 200ms", s.LongestStall)
+	}
+	if !s.EventLatency || s.MaxLatency != 100*time.Millisecond {
+		t.Errorf("EventLatency = %t, MaxLatency = %v", s.EventLatency, s.MaxLatency)
+	}
+	if s.BPSQuantiles[0] != 0 || s.BPSQuantiles[len(s.BPSQuantiles)-1] != 10000 {
+		t.Errorf("BPSQuantiles = %v", s.BPSQuantiles)
+	}
+
+	r.Events[0].Hist = new(Histogram)
+	r.Events[0].Hist.Record(time.Millisecond)
+	if s := Summarize(r); s.EventLatency || s.MaxLatency != time.Millisecond {
+		t.Errorf("with histogram: EventLatency = %t, MaxLatency = %v", s.EventLatency, s.MaxLatency)
+	}
+}
+
+func TestSummarizeAmplification(t *testing.T) {
+	r := Report{
+		Header: &Header{Env: "write", Config: []byte(`{"size":1000,"keysize":25,"datasize":100}`)},
+		Events: []Progress{{Processed: 800, Delta: 800, Duration: time.Second}},
+		Stats: []Stats{
+			{IO: &ProcIO{WriteBytes: 100}},
+			{IO: &ProcIO{WriteBytes: 2600, CancelledWriteBytes: 500}, Disk: &DiskUsage{Bytes: 1500}},
+		},
+		Trailer: &Trailer{Processed: 1000},
+	}
+	s := Summarize(r)
+	// The logical size is 1000 bytes of values and 250 bytes of keys.
+	if s.WriteAmp != 1.6 {
+		t.Errorf("WriteAmp = %v, want 1.6", s.WriteAmp)
+	}
+	if s.SpaceAmp != 1.2 {
+		t.Errorf("SpaceAmp = %v, want 1.2", s.SpaceAmp)
+	}
+}
+
+func TestSummarizeAmplificationCancelled(t *testing.T) {
+	r := Report{
+		Header: &Header{Env: "write", Config: []byte(`{"size":1000,"keysize":25,"datasize":100}`)},
+		Events: []Progress{{Processed: 1000, Delta: 1000, Duration: time.Second}},
+		Stats: []Stats{
+			{IO: &ProcIO{WriteBytes: 100}},
+			// More bytes cancelled than written during the run.
+			{IO: &ProcIO{WriteBytes: 600, CancelledWriteBytes: 700}},
+		},
+	}
+	if s := Summarize(r); s.WriteAmp != 0 {
+		t.Errorf("WriteAmp = %v, want 0", s.WriteAmp)
+	}
+}
+
+func TestSummarizeReadAmplification(t *testing.T) {
+	r := Report{
+		Header: &Header{Env: "read", Config: []byte(`{"size":1000,"keysize":25,"datasize":100}`)},
+		Events: []Progress{{Processed: 1000, Delta: 1000, Duration: time.Second}},
+		Annotations: []Annotation{
+			{Elapsed: 0, Text: "load"},
+			{Elapsed: 2 * time.Second, Text: "read"},
+		},
+		Stats: []Stats{
+			{Elapsed: 0, IO: &ProcIO{ReadBytes: 0}},
+			{Elapsed: time.Second, IO: &ProcIO{ReadBytes: 5000}}, // load
+			{Elapsed: 2 * time.Second, IO: &ProcIO{ReadBytes: 6000}},
+			{Elapsed: 3 * time.Second, IO: &ProcIO{ReadBytes: 8500}},
+		},
+	}
+	// Only reads after the "read" annotation count.
+	if s := Summarize(r); s.ReadAmp != 2 {
+		t.Errorf("ReadAmp = %v, want 2", s.ReadAmp)
+	}
+}
@@ -0,0 +1,99 @@
+package bench
+
+import "time"
+
+// stream accumulates progress of one kind of operation and turns it into
+// progress events. It is used by environments which log several kinds of
+// operations into the same file.
+//
+// The events of a timed stream report the time spent in its operations as
+// their duration instead of the wall time since the previous event. This is
+// for operations interleaved with other kinds of operations on the same
+// goroutine, where wall time would include the time spent in the others.
+// Operations of a timed stream must be started with begin.
+type stream struct {
+	op              string
+	emitter         emitter
+	timed           bool
+	lastTime        time.Duration
+	busy            time.Duration // time spent in operations since the last event
+	opStart         time.Duration // start of the running operation not counted in busy yet
+	inOp            bool          // an operation is running
+	processed, last uint64
+	ops             uint64
+	hist            Histogram
+}
+
+func newStream(op string, e emitter, now time.Duration) *stream {
+	return &stream{op: op, emitter: e, lastTime: now}
+}
+
+func newTimedStream(op string, e emitter, now time.Duration) *stream {
+	return &stream{op: op, emitter: e, timed: true, lastTime: now}
+}
+
+// begin records the start of an operation.
+func (s *stream) begin(now time.Duration) {
+	s.opStart, s.inOp = now, true
+}
+
+// add records an operation which processed w bytes and took d. It returns a
+// progress event when the emitter says one is due.
+func (s *stream) add(now time.Duration, w uint64, d time.Duration) (Progress, bool) {
+	s.processed += w
+	s.ops++
+	s.hist.Record(d)
+	if s.inOp {
+		s.busy += now - s.opStart
+		s.inOp = false
+	}
+	if !s.emitter.due(s.processed-s.last, now-s.lastTime) {
+		return Progress{}, false
+	}
+	return s.emit(now), true
+}
+
+// tick returns an event when the emit interval has passed since the last
+// event, even if no operation has completed since. The running time of an
+// unfinished operation counts as busy, so a stall of a timed stream shows up
+// as events without progress. Timed streams without running operations are
+// idle and don't write events.
+func (s *stream) tick(now time.Duration) (Progress, bool) {
+	if s.emitter.interval == 0 || now-s.lastTime < s.emitter.interval {
+		return Progress{}, false
+	}
+	if s.inOp {
+		s.busy += now - s.opStart
+		s.opStart = now
+	}
+	if s.timed && s.busy == 0 {
+		return Progress{}, false
+	}
+	return s.emit(now), true
+}
+
+// flush returns an event for any operations not reported yet.
+func (s *stream) flush(now time.Duration) (Progress, bool) {
+	if s.ops == 0 && s.processed == s.last {
+		return Progress{}, false
+	}
+	return s.emit(now), true
+}
+
+func (s *stream) emit(now time.Duration) Progress {
+	p := Progress{
+		Op:        s.op,
+		Processed: s.processed,
+		Delta:     s.processed - s.last,
+		Duration:  now - s.lastTime,
+		Ops:       s.ops,
+		Latency:   s.hist.Sum(),
+		Hist:      s.hist.snapshot(),
+	}
+	if s.timed {
+		p.Duration = s.busy
+	}
+	s.lastTime, s.last, s.ops, s.busy = now, s.processed, 0, 0
+	s.hist.Reset()
+	return p
+}
@@ -2,6 +2,7 @@ package bench
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -9,12 +10,49 @@ import (
 	"time"
 )
 
-const emitInterval = 500 * 1024 // bytes
+const defaultEmitSize = 500 * 1024 // bytes
+
+// Delete modes supported by WriteEnv.
+const (
+	DeleteRandom = "random" // delete random existing keys
+	DeleteOldest = "oldest" // delete keys in insertion order, like a queue
+)
+
+// DeleteModes lists all available delete modes.
+var DeleteModes = []string{DeleteRandom, DeleteOldest}
 
 type WriteConfig struct {
 	Size     uint64 `json:"size"`     // total size of values to write
 	KeySize  uint64 `json:"keysize"`  // size of each key written
 	DataSize uint64 `json:"datasize"` // size of each value written
+	KeyDist  string `json:"keydist"`  // key distribution, one of KeyDists
+
+	// UpdateRatio is the fraction of writes which overwrite a previously
+	// written key instead of generating a new one.
+	UpdateRatio float64 `json:"updateratio"`
+	// DeleteRatio is the fraction of operations which delete a key.
+	// DeleteMode selects which key is deleted, see DeleteModes.
+	DeleteRatio float64 `json:"deleteratio"`
+	DeleteMode  string  `json:"deletemode"`
+
+	// EmitSize is the amount of data after which a progress event is written.
+	// EmitInterval is the time after which a progress event is written, even if
+	// no progress was made. If both are zero, events are written every 500KB.
+	EmitSize     uint64        `json:"emitsize,omitempty"`
+	EmitInterval time.Duration `json:"emitinterval,omitempty"`
+
+	// StatsInterval is the interval at which statistics of samplers added with
+	// AddSampler are logged. Zero disables periodic sampling, but a final
+	// sample is still logged at the end of the run.
+	StatsInterval time.Duration `json:"statsinterval,omitempty"`
+	// DiskProfile is the name of the simulated disk the database is opened on,
+	// see DiskProfiles. Simulated disks are backed by memory, so Storage
+	// should be StorageMem.
+	DiskProfile string `json:"diskprofile,omitempty"`
+	// Storage is the storage type of the database, StorageFile if empty.
+	Storage string `json:"storage,omitempty"`
+	// CountIO makes the database count I/O per file type.
+	CountIO bool `json:"countio,omitempty"`
 
 	LogPercent bool   `json:"-"`
 	TestName   string `json:"-"`
@@ -25,31 +63,89 @@ type WriteEnv struct {
 	// generating keys and values
 	key, value []byte
 	rand       *rand.Rand
+	keys       keyGen
+	pool       *keyPool // existing keys, for generators that can't recreate them
+	fresh      uint64   // number of keys generated
+	oldest     keyGen   // replays keys in insertion order for DeleteOldest
+	nOldest    uint64   // number of keys replayed by oldest
 	out        *json.Encoder
+	header     *Header
+	emit       emitter
+	stats      *sampling
 	// reporting
 	mu                   sync.Mutex
 	startTime, lastTime  time.Duration
 	written, lastWritten uint64
+	deleted              uint64
+	calls                Histogram // latency of database calls since last event
+	totalOps             uint64
+	totalDeleted         uint64
 	lastPercent          int
 }
 
 func NewWriteEnv(output io.Writer, cfg WriteConfig) *WriteEnv {
 	return &WriteEnv{
-		cfg:   cfg,
-		out:   json.NewEncoder(output),
-		key:   make([]byte, cfg.KeySize),
-		value: make([]byte, cfg.DataSize),
+		cfg:    cfg,
+		out:    json.NewEncoder(output),
+		header: newHeader("write", cfg.TestName, cfg),
+		emit:   newEmitter(cfg.EmitSize, cfg.EmitInterval),
+		stats:  &sampling{interval: cfg.StatsInterval},
+		key:    make([]byte, cfg.KeySize),
+		value:  make([]byte, cfg.DataSize),
 	}
 }
 
-// Run calls write repeatedly with random keys and values.
+// Describe records the database directory and the benchmark implementation in
+// the log header. Exported fields of benchmark, including goleveldb options,
+// are logged. It must be called before Run.
+func (env *WriteEnv) Describe(dbdir string, benchmark interface{}) {
+	env.header.describe(dbdir, benchmark)
+}
+
+// AddSampler adds a sampler of statistics, which are logged periodically
+// according to StatsInterval. It must be called before Run.
+func (env *WriteEnv) AddSampler(s Sampler) {
+	env.stats.add(s)
+}
+
+// StorageConfig returns the storage of the test database for OpenDB.
+func (env *WriteEnv) StorageConfig() StorageConfig {
+	return StorageConfig{Storage: env.cfg.Storage, DiskProfile: env.cfg.DiskProfile, CountIO: env.cfg.CountIO}
+}
+
+// Run calls write repeatedly with keys from the configured distribution and random values.
 // The write function should perform a database write and call LegacyWriteProgress when
 // data has actually been flushed to disk.
 func (env *WriteEnv) Run(write func(key, value string, lastCall bool) error) error {
-	env.start()
+	return env.RunWithDeletes(write, nil)
+}
+
+// RunWithDeletes is like Run, but also calls del with existing keys according to
+// the configured DeleteRatio. The del function should perform a database delete and
+// call DeleteProgress when the deletion has been flushed to disk.
+func (env *WriteEnv) RunWithDeletes(write func(key, value string, lastCall bool) error, del func(key string) error) error {
+	err := env.start(del)
+	if err == nil {
+		stopTicker := env.emit.startTicker(func() { env.progress(0, 0) })
+		stopStats := env.stats.start(env.startTime, env.writeStats)
+		err = env.run(write, del)
+		stopTicker()
+		stopStats()
+	}
+	env.finish(err)
+	return err
+}
+
+func (env *WriteEnv) run(write func(key, value string, lastCall bool) error, del func(key string) error) error {
 	written := uint64(0)
 	for {
-		env.rand.Read(env.key)
+		if env.deleteKey(env.key) {
+			if err := del(string(env.key)); err != nil {
+				return err
+			}
+			continue
+		}
+		env.nextKey()
 		env.rand.Read(env.value)
 		written += env.cfg.DataSize
 		end := written >= env.cfg.Size
@@ -60,30 +156,183 @@ func (env *WriteEnv) Run(write func(key, value string, lastCall bool) error) err
 	}
 }
 
-func (env *WriteEnv) start() {
+// start writes the log header and prepares the key generators. Errors in the
+// configuration are returned after the header is written, so they end up in
+// the log trailer.
+func (env *WriteEnv) start(del func(key string) error) error {
 	env.written, env.lastWritten = 0, 0
+	env.deleted, env.totalDeleted, env.totalOps = 0, 0, 0
 	env.rand = rand.New(rand.NewSource(0x1334))
+	if err := env.header.write(env.out); err != nil {
+		return err
+	}
 	env.startTime = mononow()
 	env.lastTime = env.startTime
+
+	if env.cfg.DeleteRatio > 0 && del == nil {
+		return errors.New("benchmark does not support deletes")
+	}
+	if env.cfg.DeleteRatio >= 1 {
+		return fmt.Errorf("invalid delete ratio %v", env.cfg.DeleteRatio)
+	}
+	keys, err := newKeyGen(env.cfg.KeyDist, rand.New(rand.NewSource(keySeed)), env.keyspace())
+	if err != nil {
+		return err
+	}
+	env.keys = keys
+	env.pool = newKeyPool(rand.New(rand.NewSource(poolSeed)), len(env.key))
+	env.fresh, env.nOldest = 0, 0
+	switch env.cfg.DeleteMode {
+	case "", DeleteRandom:
+	case DeleteOldest:
+		env.oldest, _ = newKeyGen(env.cfg.KeyDist, rand.New(rand.NewSource(keySeed)), env.keyspace())
+		// Deleted keys must not be picked for updates, which would
+		// bring them back.
+		if _, ok := keys.(indexedKeyGen); !ok {
+			env.pool.enableRemove()
+		}
+	default:
+		return fmt.Errorf("unknown delete mode %q", env.cfg.DeleteMode)
+	}
+	return nil
+}
+
+// Annotate writes an annotation to the log.
+func (env *WriteEnv) Annotate(text string) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.out.Encode(newAnnotation(mononow()-env.startTime, text))
+}
+
+func (env *WriteEnv) writeStats(st *Stats) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.out.Encode(st)
+}
+
+// finish writes the log trailer.
+func (env *WriteEnv) finish(err error) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	t := newTrailer(mononow()-env.startTime, env.written, err)
+	t.Ops = env.totalOps + env.calls.Count()
+	t.Deleted = env.totalDeleted + env.deleted
+	env.out.Encode(t)
+}
+
+// nextKey fills env.key with the key of the next write. It is either a new key
+// from the generator or, according to UpdateRatio, an existing key.
+func (env *WriteEnv) nextKey() {
+	if env.fresh > 0 && env.cfg.UpdateRatio > 0 && env.rand.Float64() < env.cfg.UpdateRatio {
+		if env.existingKey(env.key) {
+			return
+		}
+	}
+	env.keys.next(env.key)
+	env.fresh++
+	if _, ok := env.keys.(indexedKeyGen); !ok {
+		env.pool.add(env.key)
+	}
+}
+
+// existingKey fills key with a random previously generated key which hasn't
+// been deleted by DeleteOldest. It returns false if there is no such key.
+func (env *WriteEnv) existingKey(key []byte) bool {
+	if g, ok := env.keys.(indexedKeyGen); ok {
+		if env.nOldest >= env.fresh {
+			return false
+		}
+		g.keyAt(key, env.nOldest+uint64(env.rand.Int63n(int64(env.fresh-env.nOldest))))
+		return true
+	}
+	return env.pool.pick(key, false)
+}
+
+// deleteKey decides whether the next operation is a delete according to
+// DeleteRatio. If so, it fills key with the key to delete and returns true.
+//
+// Random deletes of keys from indexed generators may hit keys which have
+// already been deleted.
+func (env *WriteEnv) deleteKey(key []byte) bool {
+	if env.cfg.DeleteRatio == 0 || env.fresh == 0 || env.rand.Float64() >= env.cfg.DeleteRatio {
+		return false
+	}
+	if env.oldest != nil {
+		if env.nOldest >= env.fresh {
+			return false
+		}
+		env.oldest.next(key)
+		env.nOldest++
+		env.pool.remove(key)
+		return true
+	}
+	if g, ok := env.keys.(indexedKeyGen); ok {
+		g.keyAt(key, uint64(env.rand.Int63n(int64(env.fresh))))
+		return true
+	}
+	return env.pool.pick(key, true)
+}
+
+// keyspace returns the number of values written by Run.
+func (env *WriteEnv) keyspace() uint64 {
+	if env.cfg.DataSize == 0 {
+		return env.cfg.Size
+	}
+	return (env.cfg.Size + env.cfg.DataSize - 1) / env.cfg.DataSize
 }
 
 // LegacyWriteProgress writes a JSON progress event to the environment's output writer.
 func (env *WriteEnv) Progress(w int) {
-	now := mononow()
+	env.progress(w, 0)
+}
+
+// DeleteProgress records n deleted entries. They are reported with the next
+// progress event.
+func (env *WriteEnv) DeleteProgress(n int) {
+	env.progress(0, n)
+}
+
+func (env *WriteEnv) progress(w, deleted int) {
 	env.mu.Lock()
 	defer env.mu.Unlock()
+	now := mononow()
 	env.written += uint64(w)
+	env.deleted += uint64(deleted)
 	d := now - env.lastTime
 	dw := env.written - env.lastWritten
-	if dw > 0 && dw > emitInterval {
-		p := Progress{Processed: env.written, Delta: dw, Duration: d}
+	if env.emit.due(dw, d) {
+		p := Progress{
+			Processed: env.written,
+			Delta:     dw,
+			Duration:  d,
+			Deleted:   env.deleted,
+			Ops:       env.calls.Count(),
+			Latency:   env.calls.Sum(),
+			Hist:      env.calls.snapshot(),
+		}
 		env.out.Encode(&p)
 		env.logPercentage()
 		env.lastTime = now
 		env.lastWritten = env.written
+		env.totalDeleted += env.deleted
+		env.totalOps += env.calls.Count()
+		env.deleted = 0
+		env.calls.Reset()
 	}
 }
 
+// Measure runs fn, which should perform a single database call, and records
+// its latency. Latencies are reported in progress events.
+func (env *WriteEnv) Measure(fn func() error) error {
+	start := mononow()
+	err := fn()
+	d := mononow() - start
+	env.mu.Lock()
+	env.calls.Record(d)
+	env.mu.Unlock()
+	return err
+}
+
 func (env *WriteEnv) logPercentage() {
 	if !env.cfg.LogPercent {
 		return